@@ -2,29 +2,127 @@ package traefik_aws_plugin
 
 import (
 	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
-	"github.com/bluecatengineering/traefik-aws-plugin/ecs"
+	"github.com/bluecatengineering/traefik-aws-plugin/credentials"
+	"github.com/bluecatengineering/traefik-aws-plugin/dynamodb"
 	"github.com/bluecatengineering/traefik-aws-plugin/local"
 	"github.com/bluecatengineering/traefik-aws-plugin/log"
 	"github.com/bluecatengineering/traefik-aws-plugin/s3"
+	"github.com/bluecatengineering/traefik-aws-plugin/service"
+	"github.com/bluecatengineering/traefik-aws-plugin/signer"
+	"github.com/bluecatengineering/traefik-aws-plugin/sns"
+	"github.com/bluecatengineering/traefik-aws-plugin/sqs"
+	"github.com/bluecatengineering/traefik-aws-plugin/sts"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Service stores and retrieves objects. Payloads are streamed, contentLength is -1 when unknown;
+// header holds the request headers, such as Content-Type and the preconditions of the write.
+// Get writes the object to rw itself, honoring the Range and conditional headers of req; it
+// only returns an error after starting the response when the transfer fails midway.
 type Service interface {
-	Put(name string, payload []byte, contentType string, rw http.ResponseWriter) ([]byte, error)
-	Post(name string, payload []byte, contentType string, rw http.ResponseWriter) ([]byte, error)
-	Get(name string, rw http.ResponseWriter) ([]byte, error)
+	Put(name string, payload io.Reader, contentLength int64, header http.Header, rw http.ResponseWriter) ([]byte, error)
+	Post(name string, payload io.Reader, contentLength int64, header http.Header, rw http.ResponseWriter) ([]byte, error)
+	Get(name string, req *http.Request, rw http.ResponseWriter) error
+	Delete(name string, rw http.ResponseWriter) error
+	Head(name string, req *http.Request, rw http.ResponseWriter) error
+}
+
+// Lister is implemented by services which can list the objects they store.
+type Lister interface {
+	List(options service.ListOptions) (*service.Listing, error)
+}
+
+// Presigner is implemented by services which can hand out URLs giving direct access to an object,
+// so that payloads do not flow through Traefik.
+type Presigner interface {
+	Presign(httpMethod string, name string, expires time.Duration) (string, error)
 }
 
 type Config struct {
 	TimeoutSeconds int
 	Service        string
 
+	// AWS credentials: the provider is one of env, webIdentity, shared, container or imds,
+	// or empty to try them in that order. Profile selects a profile of the shared files.
+	CredentialsProvider string
+	Profile             string
+	// RoleArn is assumed with the credentials of the provider, for instance to reach a bucket
+	// in another account. ExternalId and SessionName are passed along to STS.
+	RoleArn     string
+	ExternalId  string
+	SessionName string
+	// Temporary credentials are renewed RefreshWindowSeconds before they expire, 5 minutes by default.
+	RefreshWindowSeconds int
+
 	// S3
 	Bucket string
 	Prefix string
 	Region string
+	// Endpoint is the URL of an S3 compatible service, of DynamoDB local or of a local SNS.
+	// PathStyle puts the bucket in the path rather than the host and RegionalEndpoint selects
+	// s3.<region>.amazonaws.com.
+	Endpoint         string
+	PathStyle        bool
+	RegionalEndpoint bool
+	// Uploads larger than MultipartThreshold bytes are sent in parts of MultipartPartSize bytes,
+	// MultipartConcurrency parts at a time.
+	MultipartThreshold   int64
+	MultipartPartSize    int64
+	MultipartConcurrency int
+	// SignedHeaders are signed along with host, content-type, content-md5, range and x-amz-*.
+	SignedHeaders []string
+	// SignatureVersion is v4, the default, or v4a to sign for every region of RegionSet, all of
+	// them when empty. Multi-region access points, given as the Bucket by their ARN, use v4a.
+	SignatureVersion string
+	RegionSet        []string
+	// StreamingSignature signs payloads chunk by chunk as they are uploaded, rather than
+	// spooling them to hash them beforehand.
+	StreamingSignature bool
+	// UnsignedPayload leaves payloads out of the signature, relying on TLS, optionally sending
+	// an x-amz-checksum-* of them computed on the way with the Checksum algorithm.
+	UnsignedPayload bool
+	Checksum        string
+
+	// Presign answers GET and PUT requests with a presigned URL of the object rather than
+	// proxying them: "redirect" with a 307 redirect, "json" with a JSON body. Presigned URLs
+	// expire after PresignExpiresSeconds, 15 minutes by default.
+	Presign               string
+	PresignExpiresSeconds int
+
+	// Inbound authentication: requests must be signed with SigV4 by one of the AuthKeys, secret
+	// access keys by access key id, or of the profiles of AuthKeysFile, in the format of
+	// ~/.aws/credentials, and sent within MaxClockSkewSeconds, 15 minutes by default.
+	AuthKeys            map[string]string
+	AuthKeysFile        string
+	MaxClockSkewSeconds int
+
+	// DynamoDB: items of Table are keyed by the path, the value of PartitionKey, then of SortKey
+	// after a slash if set. VersionAttribute, "version" by default, holds the ETag of each item.
+	// Region and Endpoint are shared with S3.
+	Table            string
+	PartitionKey     string
+	SortKey          string
+	VersionAttribute string
+
+	// SQS: POST and PUT send the body to the queue at QueueUrl, with the MessageAttributeHeaders
+	// of the request as message attributes, and GET receives messages. Region defaults to the
+	// region of the queue URL.
+	QueueUrl                string
+	MessageAttributeHeaders []string
+
+	// SNS: POST publishes the body to TopicArn at the root path, or to the topic of Topics, ARNs
+	// by name, named by the path, with the MessageAttributeHeaders as message attributes.
+	// Endpoint is shared with S3.
+	TopicArn string
+	Topics   map[string]string
 
 	// Local Directory
 	Directory string
@@ -35,12 +133,34 @@ func CreateConfig() *Config {
 }
 
 type AwsPlugin struct {
-	next    http.Handler
-	name    string
-	service Service
+	next           http.Handler
+	name           string
+	service        Service
+	presign        string
+	presignExpires time.Duration
+	verifier       *signer.Verifier
 }
 
-func (plugin AwsPlugin) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+func (plugin AwsPlugin) ServeHTTP(httpRw http.ResponseWriter, req *http.Request) {
+	rw := &responseWriter{ResponseWriter: httpRw}
+	if plugin.verifier != nil {
+		if err := plugin.verifier.Verify(req); err != nil {
+			// Neither the service nor the next handler see an unauthenticated request.
+			handleResponse(nil, fmt.Errorf("%w: %s", service.ErrAccessDenied, err), rw)
+			return
+		}
+	}
+	// Services which do not list, such as queues, get every GET.
+	if _, ok := plugin.service.(Lister); ok && req.Method == http.MethodGet && isListing(req) {
+		plugin.list(rw, req)
+		plugin.next.ServeHTTP(rw, req)
+		return
+	}
+	if plugin.presign != "" && (req.Method == http.MethodGet || req.Method == http.MethodPut) {
+		plugin.presignedUrl(rw, req)
+		plugin.next.ServeHTTP(rw, req)
+		return
+	}
 	switch req.Method {
 	case http.MethodPut:
 		plugin.put(rw, req)
@@ -48,44 +168,146 @@ func (plugin AwsPlugin) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		plugin.post(rw, req)
 	case http.MethodGet:
 		plugin.get(rw, req)
+	case http.MethodDelete:
+		plugin.delete(rw, req)
+	case http.MethodHead:
+		plugin.head(rw, req)
 	default:
 		http.Error(rw, fmt.Sprintf("Method %s not implemented", req.Method), http.StatusNotImplemented)
 	}
 	plugin.next.ServeHTTP(rw, req)
 }
 
-func (plugin *AwsPlugin) put(rw http.ResponseWriter, req *http.Request) {
-	payload, err := io.ReadAll(req.Body)
+func (plugin *AwsPlugin) put(rw *responseWriter, req *http.Request) {
+	resp, err := plugin.service.Put(req.URL.Path[1:], req.Body, req.ContentLength, req.Header, rw)
+	handleResponse(resp, err, rw)
+}
+
+func (plugin *AwsPlugin) post(rw *responseWriter, req *http.Request) {
+	resp, err := plugin.service.Post(req.URL.Path[1:], req.Body, req.ContentLength, req.Header, rw)
+	handleResponse(resp, err, rw)
+}
+
+func (plugin *AwsPlugin) get(rw *responseWriter, req *http.Request) {
+	err := plugin.service.Get(req.URL.Path[1:], req, rw)
+	if err != nil && rw.wroteHeader {
+		// The status is already on its way to the client, the transfer is simply cut short.
+		log.Error(fmt.Sprintf("Get error: %s", err.Error()))
+		return
+	}
+	handleResponse(nil, err, rw)
+}
+
+func (plugin *AwsPlugin) delete(rw *responseWriter, req *http.Request) {
+	err := plugin.service.Delete(req.URL.Path[1:], rw)
 	if err != nil {
-		rw.WriteHeader(http.StatusNotAcceptable)
-		log.Error(fmt.Sprintf("Reading body failed: %s", err.Error()))
+		handleResponse(nil, err, rw)
 		return
 	}
-	resp, err := plugin.service.Put(req.URL.Path[1:], payload, req.Header.Get("Content-Type"), rw)
-	handleResponse(resp, err, rw)
+	rw.WriteHeader(http.StatusNoContent)
 }
 
-func (plugin *AwsPlugin) post(rw http.ResponseWriter, req *http.Request) {
-	payload, err := io.ReadAll(req.Body)
+func (plugin *AwsPlugin) head(rw *responseWriter, req *http.Request) {
+	err := plugin.service.Head(req.URL.Path[1:], req, rw)
+	handleResponse(nil, err, rw)
+}
+
+// isListing tells whether a GET request is for the objects under a prefix rather than an object:
+// the path ends with a slash, or the query has a list parameter.
+func isListing(req *http.Request) bool {
+	return strings.HasSuffix(req.URL.Path, "/") || req.URL.Query().Has("list")
+}
+
+// list responds with the objects whose keys start with the path, in JSON, or in the XML of
+// ListObjectsV2 with format=xml or when accepted. delimiter defaults to a slash, so that the listing reads like
+// a directory; max-keys, continuation-token and start-after page through it.
+func (plugin *AwsPlugin) list(rw *responseWriter, req *http.Request) {
+	lister := plugin.service.(Lister)
+	query := req.URL.Query()
+	options := service.ListOptions{
+		Prefix:            req.URL.Path[1:],
+		Delimiter:         "/",
+		ContinuationToken: query.Get("continuation-token"),
+		StartAfter:        query.Get("start-after"),
+		MaxKeys:           service.MaxKeys,
+	}
+	if query.Has("delimiter") {
+		options.Delimiter = query.Get("delimiter")
+	}
+	if maxKeys := query.Get("max-keys"); maxKeys != "" {
+		n, err := strconv.Atoi(maxKeys)
+		if err != nil || n < 0 {
+			handleResponse(nil, fmt.Errorf("%w: invalid max-keys %q", service.ErrBadRequest, maxKeys), rw)
+			return
+		}
+		if n < options.MaxKeys {
+			options.MaxKeys = n
+		}
+	}
+	listing, err := lister.List(options)
 	if err != nil {
-		rw.WriteHeader(http.StatusNotAcceptable)
-		log.Error(fmt.Sprintf("Reading body failed: %s", err.Error()))
+		handleResponse(nil, err, rw)
 		return
 	}
-	resp, err := plugin.service.Post(req.URL.Path[1:], payload, req.Header.Get("Content-Type"), rw)
+	if listing.Contents == nil {
+		listing.Contents = []service.Object{}
+	}
+	if listing.CommonPrefixes == nil {
+		listing.CommonPrefixes = []service.CommonPrefix{}
+	}
+	var resp []byte
+	if query.Get("format") == "xml" || strings.Contains(req.Header.Get("Accept"), "application/xml") {
+		rw.Header().Set("Content-Type", "application/xml")
+		resp, err = xml.Marshal(listing)
+		resp = append([]byte(xml.Header), resp...)
+	} else {
+		rw.Header().Set("Content-Type", "application/json")
+		resp, err = json.Marshal(listing)
+	}
 	handleResponse(resp, err, rw)
 }
 
-func (plugin *AwsPlugin) get(rw http.ResponseWriter, req *http.Request) {
-	resp, err := plugin.service.Get(req.URL.Path[1:], rw)
+// presignedResponse is the body of the response to a request in the json presign mode.
+type presignedResponse struct {
+	Url       string    `json:"url"`
+	Method    string    `json:"method"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (plugin *AwsPlugin) presignedUrl(rw *responseWriter, req *http.Request) {
+	expiresAt := time.Now().Add(plugin.presignExpires).UTC().Truncate(time.Second)
+	presignedUrl, err := plugin.service.(Presigner).Presign(req.Method, req.URL.Path[1:], plugin.presignExpires)
+	if err != nil {
+		handleResponse(nil, err, rw)
+		return
+	}
+	if plugin.presign == presignRedirect {
+		http.Redirect(rw, req, presignedUrl, http.StatusTemporaryRedirect)
+		return
+	}
+	resp, err := json.Marshal(presignedResponse{Url: presignedUrl, Method: req.Method, ExpiresAt: expiresAt})
+	if err == nil {
+		rw.Header().Set("Content-Type", "application/json")
+	}
 	handleResponse(resp, err, rw)
 }
 
-func handleResponse(resp []byte, reqErr error, rw http.ResponseWriter) {
+func handleResponse(resp []byte, reqErr error, rw *responseWriter) {
+	if errors.Is(reqErr, service.ErrNotModified) {
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
 	if reqErr != nil {
-		rw.WriteHeader(http.StatusInternalServerError)
-		http.Error(rw, fmt.Sprintf("Put error: %s", reqErr.Error()), http.StatusInternalServerError)
-		log.Error(reqErr.Error())
+		status := statusCode(reqErr)
+		if status >= http.StatusInternalServerError {
+			log.Error(reqErr.Error())
+		} else {
+			log.Debug(reqErr.Error())
+		}
+		http.Error(rw, errorBody(reqErr, status), status)
+		return
+	}
+	if rw.wroteHeader {
 		return
 	}
 	rw.WriteHeader(http.StatusOK)
@@ -95,17 +317,207 @@ func handleResponse(resp []byte, reqErr error, rw http.ResponseWriter) {
 	}
 }
 
+// errorBody is what clients are told of an error: its status, and the code of an S3 error. The
+// error itself, with the bucket, keys, paths and request ids it mentions, is only logged.
+func errorBody(err error, status int) string {
+	var s3Err *s3.Error
+	if errors.As(err, &s3Err) && s3Err.Code != "" {
+		return http.StatusText(status) + ": " + s3Err.Code
+	}
+	return http.StatusText(status)
+}
+
+// statusCode translates the errors of the services into HTTP statuses.
+func statusCode(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, service.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrAccessDenied):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, service.ErrRangeNotSatisfiable):
+		return http.StatusRequestedRangeNotSatisfiable
+	case errors.Is(err, service.ErrThrottled):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, service.ErrTooLarge), errors.As(err, &maxBytesErr):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, service.ErrBadRequest), errors.Is(err, signer.ErrPayloadMismatch):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrNotImplemented):
+		return http.StatusNotImplemented
+	}
+	return http.StatusInternalServerError
+}
+
+// responseWriter records whether a service has already started the response.
+type responseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (rw *responseWriter) WriteHeader(statusCode int) {
+	rw.wroteHeader = true
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	return rw.ResponseWriter.Write(b)
+}
+
 func New(_ context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
 	plugin := &AwsPlugin{next: next, name: name}
 	switch config.Service {
 	case "s3":
-		plugin.service = s3.New(config.Bucket, config.Prefix, config.Region, config.TimeoutSeconds, ecs.GetCredentials())
-		return plugin, nil
+		multipart := s3.Multipart{
+			Threshold:   config.MultipartThreshold,
+			PartSize:    config.MultipartPartSize,
+			Concurrency: config.MultipartConcurrency,
+		}
+		endpoint := s3.Endpoint{
+			URL:       config.Endpoint,
+			PathStyle: config.PathStyle,
+			Regional:  config.RegionalEndpoint,
+		}
+		signing := s3.Signing{
+			SignedHeaders: config.SignedHeaders,
+			RegionSet:     config.RegionSet,
+			Streaming:     config.StreamingSignature,
+			Unsigned:      config.UnsignedPayload,
+			Checksum:      config.Checksum,
+		}
+		switch config.SignatureVersion {
+		case "", "v4":
+		case "v4a":
+			signing.V4a = true
+		default:
+			log.Error(fmt.Sprintf("unknown signature version: %s", config.SignatureVersion))
+			return next, fmt.Errorf("invalid config: unknown signature version %q", config.SignatureVersion)
+		}
+		creds, err := newCredentialsStore(config)
+		if err != nil {
+			log.Error(err.Error())
+			return next, fmt.Errorf("invalid config: %w", err)
+		}
+		s3Service, err := s3.New(config.Bucket, config.Prefix, config.Region, config.TimeoutSeconds, endpoint, multipart, signing, creds)
+		if err != nil {
+			log.Error(err.Error())
+			return next, fmt.Errorf("invalid config: %w", err)
+		}
+		plugin.service = s3Service
+	case "dynamodb":
+		creds, err := newCredentialsStore(config)
+		if err != nil {
+			log.Error(err.Error())
+			return next, fmt.Errorf("invalid config: %w", err)
+		}
+		dynamoService, err := dynamodb.New(config.Table, config.PartitionKey, config.SortKey, config.VersionAttribute, config.Region, config.TimeoutSeconds, config.Endpoint, creds)
+		if err != nil {
+			log.Error(err.Error())
+			return next, fmt.Errorf("invalid config: %w", err)
+		}
+		plugin.service = dynamoService
+	case "sqs":
+		creds, err := newCredentialsStore(config)
+		if err != nil {
+			log.Error(err.Error())
+			return next, fmt.Errorf("invalid config: %w", err)
+		}
+		sqsService, err := sqs.New(config.QueueUrl, config.MessageAttributeHeaders, config.Region, config.TimeoutSeconds, creds)
+		if err != nil {
+			log.Error(err.Error())
+			return next, fmt.Errorf("invalid config: %w", err)
+		}
+		plugin.service = sqsService
+	case "sns":
+		creds, err := newCredentialsStore(config)
+		if err != nil {
+			log.Error(err.Error())
+			return next, fmt.Errorf("invalid config: %w", err)
+		}
+		snsService, err := sns.New(config.TopicArn, config.Topics, config.MessageAttributeHeaders, config.TimeoutSeconds, config.Endpoint, creds)
+		if err != nil {
+			log.Error(err.Error())
+			return next, fmt.Errorf("invalid config: %w", err)
+		}
+		plugin.service = snsService
 	case "local":
 		plugin.service = local.New(config.Directory)
-		return plugin, nil
 	default:
 		log.Error(fmt.Sprintf("unknown service: %s", config.Service))
+		return next, fmt.Errorf("invalid config: %v", config)
+	}
+	if err := plugin.configurePresign(config); err != nil {
+		log.Error(err.Error())
+		return next, fmt.Errorf("invalid config: %w", err)
+	}
+	if err := plugin.configureAuth(config); err != nil {
+		log.Error(err.Error())
+		return next, fmt.Errorf("invalid config: %w", err)
+	}
+	return plugin, nil
+}
+
+// newCredentialsStore keeps the credentials of the configured provider, or of the role it assumes.
+func newCredentialsStore(config *Config) (*credentials.Store, error) {
+	provider, err := credentials.NewProvider(config.CredentialsProvider, config.Profile)
+	if err != nil {
+		return nil, err
+	}
+	if config.RoleArn != "" {
+		provider = sts.NewAssumeRole(provider, config.RoleArn, config.ExternalId, config.SessionName, config.Region)
+	}
+	return credentials.NewStore(provider, time.Duration(config.RefreshWindowSeconds)*time.Second), nil
+}
+
+const (
+	presignRedirect = "redirect"
+	presignJson     = "json"
+
+	defaultPresignExpires = 15 * time.Minute
+	// SigV4 presigned URLs are valid for a week at most.
+	maxPresignExpires = 7 * 24 * time.Hour
+)
+
+func (plugin *AwsPlugin) configurePresign(config *Config) error {
+	switch config.Presign {
+	case "":
+		return nil
+	case presignRedirect, presignJson:
+	default:
+		return fmt.Errorf("unknown presign mode %q", config.Presign)
+	}
+	if _, ok := plugin.service.(Presigner); !ok {
+		return fmt.Errorf("service %s does not support presigned URLs", config.Service)
+	}
+	plugin.presign = config.Presign
+	plugin.presignExpires = time.Duration(config.PresignExpiresSeconds) * time.Second
+	if plugin.presignExpires <= 0 {
+		plugin.presignExpires = defaultPresignExpires
+	}
+	if plugin.presignExpires > maxPresignExpires {
+		return fmt.Errorf("presigned URLs cannot expire after more than %s", maxPresignExpires)
+	}
+	return nil
+}
+
+func (plugin *AwsPlugin) configureAuth(config *Config) error {
+	if len(config.AuthKeys) == 0 && config.AuthKeysFile == "" {
+		return nil
+	}
+	keys := map[string]string{}
+	if config.AuthKeysFile != "" {
+		fileKeys, err := credentials.ReadKeys(config.AuthKeysFile)
+		if err != nil {
+			return err
+		}
+		keys = fileKeys
+	}
+	for accessKeyId, secretKey := range config.AuthKeys {
+		keys[accessKeyId] = secretKey
 	}
-	return next, fmt.Errorf("invalid config: %v", config)
+	plugin.verifier = &signer.Verifier{Keys: keys, MaxSkew: time.Duration(config.MaxClockSkewSeconds) * time.Second}
+	return nil
 }
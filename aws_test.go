@@ -1,7 +1,23 @@
 package traefik_aws_plugin
 
 import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/credentials"
+	"github.com/bluecatengineering/traefik-aws-plugin/service"
+	"github.com/bluecatengineering/traefik-aws-plugin/signer"
 )
 
 func TestInitializeStorage(t *testing.T) {
@@ -9,3 +25,398 @@ func TestInitializeStorage(t *testing.T) {
 
 	})
 }
+
+func newLocalPlugin(t *testing.T) http.Handler {
+	config := CreateConfig()
+	config.Service = "local"
+	config.Directory = t.TempDir()
+	// Mimics noop@internal, which the plugin is meant to be chained with.
+	next := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	handler, err := New(context.Background(), next, config, "aws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+func TestLocalPutGet(t *testing.T) {
+	handler := newLocalPlugin(t)
+
+	payload := strings.Repeat("streamed ", 1<<16)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/object.txt", strings.NewReader(payload)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT: expected status %d, found %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/object.txt", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET: expected status %d, found %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if rec.Body.String() != payload {
+		t.Errorf("GET: payload mismatch, found %d bytes", rec.Body.Len())
+	}
+	if rec.Header().Get("Content-Length") != strconv.Itoa(len(payload)) {
+		t.Errorf("GET: unexpected Content-Length %q", rec.Header().Get("Content-Length"))
+	}
+}
+
+func TestLocalAppend(t *testing.T) {
+	handler := newLocalPlugin(t)
+	var etags []string
+	for _, payload := range []string{"first,", "second"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/object.txt", strings.NewReader(payload)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("PUT: expected status %d, found %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+		etags = append(etags, rec.Header().Get("ETag"))
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/object.txt", nil))
+	if rec.Body.String() != "first,second" {
+		t.Errorf("GET: expected the payloads appended, found %q", rec.Body.String())
+	}
+	// MD5 of "first,second".
+	if etag := rec.Header().Get("ETag"); etag != etags[1] || etag != `"c7ff4ce097bacaab89fb25f492614539"` {
+		t.Errorf("GET: expected the ETag of the whole file, found %q after %v", etag, etags)
+	}
+}
+
+func TestLocalConcurrentAppend(t *testing.T) {
+	handler := newLocalPlugin(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(payload string) {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/object.txt", strings.NewReader(payload)))
+			if rec.Code != http.StatusOK {
+				t.Errorf("PUT: expected status %d, found %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+			}
+		}(strings.Repeat(string(rune('a'+i)), 1<<12))
+	}
+	wg.Wait()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/object.txt", nil))
+	if rec.Body.Len() != 16<<12 {
+		t.Fatalf("GET: expected every payload appended, found %d bytes", rec.Body.Len())
+	}
+	for i := 0; i < rec.Body.Len(); i += 1 << 12 {
+		if chunk := rec.Body.String()[i : i+1<<12]; strings.Count(chunk, chunk[:1]) != len(chunk) {
+			t.Fatalf("GET: payloads interleaved at offset %d", i)
+		}
+	}
+	sum := md5.Sum(rec.Body.Bytes())
+	if etag := rec.Header().Get("ETag"); etag != `"`+hex.EncodeToString(sum[:])+`"` {
+		t.Errorf("GET: expected the ETag of the whole file, found %q", etag)
+	}
+}
+
+func TestLocalDelete(t *testing.T) {
+	handler := newLocalPlugin(t)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/object.txt", strings.NewReader("payload")))
+
+	for _, expected := range []int{http.StatusNoContent, http.StatusNotFound} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/object.txt", nil))
+		if rec.Code != expected {
+			t.Errorf("DELETE: expected status %d, found %d", expected, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/object.txt", nil))
+	if rec.Code == http.StatusOK {
+		t.Errorf("GET: expected the object to be deleted")
+	}
+}
+
+func TestLocalHead(t *testing.T) {
+	handler := newLocalPlugin(t)
+	req := httptest.NewRequest(http.MethodPut, "/object.json", strings.NewReader(`{"hello":"world"}`))
+	req.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/object.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("HEAD: expected status %d, found %d", http.StatusOK, rec.Code)
+	}
+	expected := map[string]string{
+		"Content-Length": "17",
+		"Content-Type":   "application/json",
+		"ETag":           `"fbc24bcc7a1794758fc1327fcfebdaf6"`,
+	}
+	for header, value := range expected {
+		if rec.Header().Get(header) != value {
+			t.Errorf("HEAD: expected %s %q, found %q", header, value, rec.Header().Get(header))
+		}
+	}
+	if rec.Header().Get("Last-Modified") == "" {
+		t.Error("HEAD: expected Last-Modified")
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("HEAD: expected no body, found %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/missing.json", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("HEAD: expected status %d, found %d", http.StatusNotFound, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/missing.json", nil))
+	if rec.Code != http.StatusNotFound || rec.Body.String() != "Not Found\n" {
+		t.Errorf("GET: expected a bare 404 without the path of the file, found %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestLocalRange(t *testing.T) {
+	handler := newLocalPlugin(t)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/object.txt", strings.NewReader("0123456789")))
+
+	testCases := []struct {
+		header       string
+		status       int
+		contentRange string
+		body         string
+	}{
+		{header: "bytes=2-5", status: http.StatusPartialContent, contentRange: "bytes 2-5/10", body: "2345"},
+		{header: "bytes=-3", status: http.StatusPartialContent, contentRange: "bytes 7-9/10", body: "789"},
+		{header: "bytes=20-", status: http.StatusRequestedRangeNotSatisfiable, contentRange: "bytes */10"},
+	}
+
+	for _, tt := range testCases {
+		req := httptest.NewRequest(http.MethodGet, "/object.txt", nil)
+		req.Header.Set("Range", tt.header)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected status %d, found %d", tt.header, tt.status, rec.Code)
+		}
+		if rec.Header().Get("Content-Range") != tt.contentRange {
+			t.Errorf("%s: expected Content-Range %q, found %q", tt.header, tt.contentRange, rec.Header().Get("Content-Range"))
+		}
+		if tt.body != "" && rec.Body.String() != tt.body {
+			t.Errorf("%s: expected %q, found %q", tt.header, tt.body, rec.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/object.txt", nil)
+	req.Header.Set("Range", "bytes=0-1,8-9")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPartialContent || !strings.HasPrefix(rec.Header().Get("Content-Type"), "multipart/byteranges") {
+		t.Errorf("multiple ranges: expected a multipart/byteranges response, found %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestLocalConditional(t *testing.T) {
+	handler := newLocalPlugin(t)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/object.txt", strings.NewReader("payload")))
+	etag := rec.Header().Get("ETag")
+
+	testCases := []struct {
+		name   string
+		method string
+		header string
+		value  string
+		status int
+	}{
+		{name: "create only", method: http.MethodPut, header: "If-None-Match", value: "*", status: http.StatusPreconditionFailed},
+		{name: "stale update", method: http.MethodPut, header: "If-Match", value: `"stale"`, status: http.StatusPreconditionFailed},
+		{name: "cached", method: http.MethodGet, header: "If-None-Match", value: etag, status: http.StatusNotModified},
+		{name: "cached head", method: http.MethodHead, header: "If-None-Match", value: etag, status: http.StatusNotModified},
+		{name: "modified", method: http.MethodGet, header: "If-Match", value: `"stale"`, status: http.StatusPreconditionFailed},
+		{name: "unmodified since", method: http.MethodGet, header: "If-Unmodified-Since", value: "Mon, 02 Jan 2006 15:04:05 GMT", status: http.StatusPreconditionFailed},
+		{name: "modified since", method: http.MethodGet, header: "If-Modified-Since", value: "Mon, 02 Jan 2006 15:04:05 GMT", status: http.StatusOK},
+		{name: "weak update", method: http.MethodPut, header: "If-Match", value: "W/" + etag, status: http.StatusPreconditionFailed},
+		{name: "weak cached", method: http.MethodGet, header: "If-None-Match", value: "W/" + etag, status: http.StatusNotModified},
+		{name: "update", method: http.MethodPut, header: "If-Match", value: etag, status: http.StatusOK},
+	}
+
+	for _, tt := range testCases {
+		req := httptest.NewRequest(tt.method, "/object.txt", strings.NewReader("updated"))
+		req.Header.Set(tt.header, tt.value)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected status %d, found %d", tt.name, tt.status, rec.Code)
+		}
+	}
+}
+
+func TestPresign(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "KEY")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "SECRET")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	config := CreateConfig()
+	config.Service = "s3"
+	config.CredentialsProvider = "env"
+	config.Bucket = "bucket"
+	config.Region = "eu-west-1"
+	config.Endpoint = "https://s3.example.com"
+	config.PathStyle = true
+	next := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+	config.Presign = "redirect"
+	handler, err := New(context.Background(), next, config, "aws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/object.txt", nil))
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	if rec.Code != http.StatusTemporaryRedirect || location == nil || location.Path != "/bucket/object.txt" || location.Query().Get("X-Amz-Signature") == "" {
+		t.Errorf("redirect: expected a presigned URL, found %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	config.Presign = "json"
+	config.PresignExpiresSeconds = 60
+	handler, err = New(context.Background(), next, config, "aws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/object.txt", strings.NewReader("payload")))
+	presigned := &presignedResponse{}
+	if err = json.Unmarshal(rec.Body.Bytes(), presigned); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("json: expected a JSON body, found %d %s", rec.Code, rec.Body.String())
+	}
+	location, _ = url.Parse(presigned.Url)
+	if presigned.Method != http.MethodPut || location.Query().Get("X-Amz-Expires") != "60" {
+		t.Errorf("json: unexpected presigned URL %+v", presigned)
+	}
+
+	config.Service = "local"
+	if _, err = New(context.Background(), next, config, "aws"); err == nil {
+		t.Error("local: expected presigned URLs to be refused")
+	}
+}
+
+func TestLocalList(t *testing.T) {
+	config := CreateConfig()
+	config.Service = "local"
+	config.Directory = t.TempDir()
+	handler, err := New(context.Background(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), config, "aws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = os.MkdirAll(filepath.Join(config.Directory, "a"), 0755)
+	_ = os.MkdirAll(filepath.Join(config.Directory, "b", "c"), 0755)
+	for _, name := range []string{"root.txt", "a/1.txt", "a/2.txt", "a/3.txt", "b/c/4.txt"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/"+name, strings.NewReader(name)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("PUT %s: expected status %d, found %d: %s", name, http.StatusOK, rec.Code, rec.Body.String())
+		}
+	}
+
+	list := func(target string) *service.Listing {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		listing := &service.Listing{}
+		if err := json.Unmarshal(rec.Body.Bytes(), listing); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected a listing, found %d %s", target, rec.Code, rec.Body.String())
+		}
+		return listing
+	}
+
+	listing := list("/")
+	if len(listing.Contents) != 1 || listing.Contents[0].Key != "root.txt" || listing.Contents[0].Size != 8 || listing.Contents[0].ETag == "" {
+		t.Errorf("/: unexpected contents %+v", listing.Contents)
+	}
+	if len(listing.CommonPrefixes) != 2 || listing.CommonPrefixes[0].Prefix != "a/" || listing.CommonPrefixes[1].Prefix != "b/" {
+		t.Errorf("/: unexpected common prefixes %+v", listing.CommonPrefixes)
+	}
+
+	var keys []string
+	target := "/a/?max-keys=2"
+	for target != "" {
+		listing = list(target)
+		for _, object := range listing.Contents {
+			keys = append(keys, object.Key)
+		}
+		target = ""
+		if listing.IsTruncated {
+			target = "/a/?max-keys=2&continuation-token=" + url.QueryEscape(listing.NextContinuationToken)
+		}
+	}
+	if strings.Join(keys, ",") != "a/1.txt,a/2.txt,a/3.txt" {
+		t.Errorf("/a/: unexpected keys %v", keys)
+	}
+
+	listing = list("/b?list&delimiter=")
+	if len(listing.Contents) != 1 || listing.Contents[0].Key != "b/c/4.txt" {
+		t.Errorf("/b?list: unexpected contents %+v", listing.Contents)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/a/?format=xml", nil))
+	if !strings.Contains(rec.Body.String(), "<ListBucketResult><Prefix>a/</Prefix>") || !strings.Contains(rec.Body.String(), "<Key>a/1.txt</Key>") {
+		t.Errorf("xml: unexpected listing %s", rec.Body.String())
+	}
+}
+
+func TestAuth(t *testing.T) {
+	keysFile := filepath.Join(t.TempDir(), "keys")
+	_ = os.WriteFile(keysFile, []byte("[reader]\naws_access_key_id = READER\naws_secret_access_key = READER_SECRET\n"), 0600)
+	config := CreateConfig()
+	config.Service = "local"
+	config.Directory = t.TempDir()
+	config.AuthKeysFile = keysFile
+	config.AuthKeys = map[string]string{"WRITER": "WRITER_SECRET"}
+	nextCalled := false
+	next := http.HandlerFunc(func(http.ResponseWriter, *http.Request) { nextCalled = true })
+	handler, err := New(context.Background(), next, config, "aws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := func(req *http.Request, accessKeyId, secretKey, payload string) *http.Request {
+		crTemplate := signer.CanonRequest{
+			Creds:   &credentials.Credentials{AccessKeyId: accessKeyId, AccessSecretKey: secretKey},
+			Region:  "us-east-1",
+			Service: "s3",
+		}
+		cr := signer.CreateCanonRequest(req, signer.HashPayload([]byte(payload)), crTemplate)
+		req.Header.Set("Authorization", cr.AuthHeader())
+		return req
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/object.txt", strings.NewReader("payload")))
+	if rec.Code != http.StatusForbidden || nextCalled {
+		t.Errorf("unsigned: expected status %d without the next handler, found %d", http.StatusForbidden, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, signed(httptest.NewRequest(http.MethodPut, "/object.txt", strings.NewReader("payload")), "WRITER", "WRONG", "payload"))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("wrong secret: expected status %d, found %d", http.StatusForbidden, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, signed(httptest.NewRequest(http.MethodPut, "/object.txt", strings.NewReader("payload")), "WRITER", "WRITER_SECRET", "payload"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT: expected status %d, found %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, signed(httptest.NewRequest(http.MethodPut, "/other.txt", strings.NewReader("tampered")), "WRITER", "WRITER_SECRET", "payload"))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("tampered payload: expected status %d, found %d", http.StatusBadRequest, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, signed(httptest.NewRequest(http.MethodGet, "/object.txt", nil), "READER", "READER_SECRET", ""))
+	if rec.Code != http.StatusOK || rec.Body.String() != "payload" {
+		t.Errorf("GET: expected the object, found %d %q", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,111 @@
+package awsclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/credentials"
+	"github.com/bluecatengineering/traefik-aws-plugin/log"
+	"github.com/bluecatengineering/traefik-aws-plugin/signer"
+)
+
+// Protocol is how the errors of a service read.
+type Protocol struct {
+	// Service names the service in errors.
+	Service string
+	// ErrorCodes map the codes of the service to the matching service errors.
+	ErrorCodes map[string]error
+	// DecodeError reads the code, message and request id of an error response body.
+	DecodeError func(body []byte, awsErr *Error)
+}
+
+// Client calls the actions of a service, signed with the credentials of a store.
+type Client struct {
+	client         *http.Client
+	protocol       Protocol
+	creds          *credentials.Store
+	timeoutSeconds int
+}
+
+// Call is an action, POSTed to Endpoint with Payload as the body.
+type Call struct {
+	Action   string
+	Endpoint string
+	Header   http.Header
+	Payload  []byte
+	// CanonRequest is the template the call is signed with, its region and service.
+	CanonRequest signer.CanonRequest
+	// Wait extends the timeout of calls the service holds on to, such as long polls.
+	Wait time.Duration
+}
+
+// New returns a client for the service speaking protocol, whose calls time out after
+// timeoutSeconds.
+func New(protocol Protocol, timeoutSeconds int, creds *credentials.Store) *Client {
+	return &Client{
+		client:         &http.Client{},
+		protocol:       protocol,
+		creds:          creds,
+		timeoutSeconds: timeoutSeconds,
+	}
+}
+
+// Call sends call and returns the body of the response, or an *Error for a response other
+// than 200. A call rejected because of stale credentials is retried once with renewed ones.
+func (client *Client) Call(call *Call) ([]byte, error) {
+	timeout := time.Duration(client.timeoutSeconds) * time.Second
+	creds, err := client.creds.Get(timeout)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	body, err := client.callSigned(creds, timeout+call.Wait, call)
+	var awsErr *Error
+	if errors.As(err, &awsErr) && credentialsErrorCodes[awsErr.Code] {
+		log.Debug(fmt.Sprintf("%s: renewing credentials: %s", call.Action, awsErr.Code))
+		creds, renewErr := client.creds.Renew(creds, timeout)
+		if renewErr != nil {
+			log.Error(renewErr.Error())
+			return nil, err
+		}
+		body, err = client.callSigned(creds, timeout+call.Wait, call)
+	}
+	return body, err
+}
+
+func (client *Client) callSigned(creds *credentials.Credentials, timeout time.Duration, call *Call) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, call.Endpoint, bytes.NewReader(call.Payload))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range call.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Host", req.URL.Host)
+	crTemplate := call.CanonRequest
+	crTemplate.Creds = creds
+	cr := signer.CreateCanonRequest(req, signer.HashPayload(call.Payload), crTemplate)
+	req.Header.Set("Authorization", cr.AuthHeader())
+
+	resp, err := client.client.Do(req)
+	if err != nil {
+		log.Error(fmt.Sprintf("%s failed, error: %s", call.Action, err.Error()))
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		awsErr := client.newError(resp)
+		if signatureErrorCodes[awsErr.Code] {
+			log.Debug(fmt.Sprintf("canonical request:\n%s", cr.RequestString()))
+		}
+		return nil, fmt.Errorf("%s: %w", call.Action, awsErr)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,58 @@
+package awsclient
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/credentials"
+	"github.com/bluecatengineering/traefik-aws-plugin/service"
+	"github.com/bluecatengineering/traefik-aws-plugin/signer"
+)
+
+func TestCall(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if req.Header.Get("X-Amz-Target") != "Service.Action" || !strings.Contains(req.Header.Get("Authorization"), "/us-east-1/service/aws4_request") {
+			t.Errorf("unexpected request %v", req.Header)
+		}
+		rw.Header().Set("X-Amzn-Requestid", "request")
+		switch req.URL.Path {
+		case "/expired":
+			if calls == 1 {
+				rw.WriteHeader(http.StatusBadRequest)
+				_, _ = rw.Write([]byte(`{"__type":"com.amazonaws.service#ExpiredTokenException","message":"expired"}`))
+				return
+			}
+			_, _ = rw.Write([]byte(`{}`))
+		default:
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte(`{"__type":"com.amazonaws.service#MissingThing","message":"missing"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	protocol := Protocol{Service: "Service", ErrorCodes: map[string]error{"MissingThing": service.ErrNotFound}, DecodeError: DecodeJSONError}
+	client := New(protocol, 5, credentials.NewStore(&credentials.Static{AccessKeyId: "KEY", AccessSecretKey: "SECRET"}, 0))
+	call := func(path string) ([]byte, error) {
+		header := http.Header{}
+		header.Set("X-Amz-Target", "Service.Action")
+		return client.Call(&Call{
+			Action:       "Action",
+			Endpoint:     server.URL + path,
+			Header:       header,
+			CanonRequest: signer.CanonRequest{Region: "us-east-1", Service: "service"},
+		})
+	}
+
+	if body, err := call("/expired"); err != nil || string(body) != `{}` || calls != 2 {
+		t.Errorf("expected the call to be retried with renewed credentials, found %s, %v after %d calls", body, err, calls)
+	}
+	_, err := call("/missing")
+	var awsErr *Error
+	if !errors.Is(err, service.ErrNotFound) || !errors.As(err, &awsErr) || awsErr.Code != "MissingThing" || awsErr.RequestId != "request" {
+		t.Errorf("expected the error code to be mapped, found %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package awsclient
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Error responses are small, anything beyond is not worth reading.
+const maxErrorBody = 64 << 10
+
+// Error is the error response of a service. It unwraps to the matching service error, if any.
+type Error struct {
+	Service    string
+	StatusCode int
+	Code       string
+	Message    string
+	RequestId  string
+	codes      map[string]error
+}
+
+// Credentials which AWS does not accept anymore, which are worth renewing before giving up.
+var credentialsErrorCodes = map[string]bool{
+	"ExpiredToken":                true,
+	"ExpiredTokenException":       true,
+	"InvalidClientTokenId":        true,
+	"UnrecognizedClientException": true,
+}
+
+// Signatures the service computed otherwise, which the canonical request helps to understand.
+var signatureErrorCodes = map[string]bool{
+	"SignatureDoesNotMatch":     true,
+	"InvalidSignatureException": true,
+}
+
+// newError reads the error from a response; the body is left for the caller to close.
+func (client *Client) newError(resp *http.Response) *Error {
+	awsErr := &Error{Service: client.protocol.Service, StatusCode: resp.StatusCode, codes: client.protocol.ErrorCodes}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if err == nil && len(body) > 0 {
+		client.protocol.DecodeError(body, awsErr)
+	}
+	if awsErr.RequestId == "" {
+		awsErr.RequestId = resp.Header.Get("X-Amzn-Requestid")
+	}
+	return awsErr
+}
+
+// DecodeJSONError reads the errors of the JSON protocols, named by their exception.
+// https://smithy.io/2.0/aws/protocols/aws-json-1_0-protocol.html#operation-error-serialization
+func DecodeJSONError(body []byte, awsErr *Error) {
+	decoded := &struct {
+		Type    string `json:"__type"`
+		Message string `json:"message"`
+	}{}
+	if json.Unmarshal(body, decoded) != nil {
+		return
+	}
+	// com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException
+	if i := strings.LastIndex(decoded.Type, "#"); i >= 0 {
+		decoded.Type = decoded.Type[i+1:]
+	}
+	awsErr.Code = decoded.Type
+	awsErr.Message = decoded.Message
+}
+
+// DecodeXMLError reads the errors of the query protocol, whose body holds the request id.
+// https://smithy.io/2.0/aws/protocols/aws-query-protocol.html#operation-error-serialization
+func DecodeXMLError(body []byte, awsErr *Error) {
+	decoded := &struct {
+		Code      string `xml:"Error>Code"`
+		Message   string `xml:"Error>Message"`
+		RequestId string `xml:"RequestId"`
+	}{}
+	if xml.Unmarshal(body, decoded) != nil {
+		return
+	}
+	awsErr.Code = decoded.Code
+	awsErr.Message = decoded.Message
+	awsErr.RequestId = decoded.RequestId
+}
+
+func (awsErr *Error) Error() string {
+	return fmt.Sprintf("%s responded with %d %s: %s (request id %s)", awsErr.Service, awsErr.StatusCode, awsErr.Code, awsErr.Message, awsErr.RequestId)
+}
+
+func (awsErr *Error) Unwrap() error {
+	return awsErr.codes[awsErr.Code]
+}
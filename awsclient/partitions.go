@@ -0,0 +1,10 @@
+package awsclient
+
+// PartitionSuffixes are the DNS suffixes of the endpoints of the partitions, by the name ARNs
+// give them.
+// https://docs.aws.amazon.com/whitepapers/latest/aws-fault-isolation-boundaries/partitions.html
+var PartitionSuffixes = map[string]string{
+	"aws":        "amazonaws.com",
+	"aws-cn":     "amazonaws.com.cn",
+	"aws-us-gov": "amazonaws.com",
+}
@@ -0,0 +1,104 @@
+package credentials
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const ecsCredentialsHost = "http://169.254.170.2"
+
+// Hosts allowed to serve credentials over plain HTTP, besides loopback addresses.
+var containerHosts = map[string]bool{
+	"169.254.170.2":  true, // ECS
+	"169.254.170.23": true, // EKS Pod Identity
+	"fd00:ec2::23":   true,
+}
+
+// Container retrieves credentials from the endpoint of the container: ECS task roles through
+// AWS_CONTAINER_CREDENTIALS_RELATIVE_URI, or AWS_CONTAINER_CREDENTIALS_FULL_URI authorized with
+// AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE or AWS_CONTAINER_AUTHORIZATION_TOKEN, as EKS Pod Identity does.
+// https://docs.aws.amazon.com/sdkref/latest/guide/feature-container-credentials.html
+type Container struct {
+	client *http.Client
+}
+
+func (container *Container) Retrieve() (*Credentials, error) {
+	credsUri, err := containerCredentialsUri()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, credsUri, nil)
+	if err != nil {
+		return nil, err
+	}
+	token, err := containerAuthorizationToken()
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	if container.client == nil {
+		container.client = &http.Client{Timeout: 5 * time.Second}
+	}
+	resp, err := container.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("container credentials endpoint responded with %s: %s", resp.Status, body)
+	}
+	creds := &Credentials{}
+	if err = json.Unmarshal(body, creds); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// https://docs.aws.amazon.com/AmazonECS/latest/developerguide/task-iam-roles.html
+func containerCredentialsUri() (string, error) {
+	if relativeUri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relativeUri != "" {
+		return ecsCredentialsHost + relativeUri, nil
+	}
+	fullUri := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if fullUri == "" {
+		return "", errors.New("container credentials: AWS_CONTAINER_CREDENTIALS_RELATIVE_URI and AWS_CONTAINER_CREDENTIALS_FULL_URI are not set")
+	}
+	u, err := url.Parse(fullUri)
+	if err != nil {
+		return "", fmt.Errorf("container credentials: %w", err)
+	}
+	if u.Scheme == "https" {
+		return fullUri, nil
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); u.Scheme == "http" && (containerHosts[host] || ip != nil && ip.IsLoopback() || host == "localhost") {
+		return fullUri, nil
+	}
+	return "", fmt.Errorf("container credentials: %q must use https or a loopback or container host", fullUri)
+}
+
+func containerAuthorizationToken() (string, error) {
+	if tokenFile := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); tokenFile != "" {
+		// Read on every retrieval, the token is rotated.
+		token, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("container credentials: %w", err)
+		}
+		return strings.TrimSpace(string(token)), nil
+	}
+	return os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"), nil
+}
@@ -0,0 +1,79 @@
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Credentials are AWS access keys. Temporary credentials come with a session token and an expiration.
+type Credentials struct {
+	AccessKeyId     string    `json:"AccessKeyId"`
+	AccessSecretKey string    `json:"SecretAccessKey"`
+	SecurityToken   string    `json:"Token"`
+	RoleArn         string    `json:"RoleArn"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// Provider retrieves credentials from one of the sources the AWS SDKs know about.
+// https://docs.aws.amazon.com/sdkref/latest/guide/standardized-credentials.html
+type Provider interface {
+	Retrieve() (*Credentials, error)
+}
+
+// Static provides the same credentials, set in code, on every retrieval.
+type Static Credentials
+
+func (static *Static) Retrieve() (*Credentials, error) {
+	creds := Credentials(*static)
+	return &creds, nil
+}
+
+// NewProvider returns the provider called name, or the default chain when name is empty.
+// profile selects the profile of the shared credentials and config files.
+func NewProvider(name string, profile string) (Provider, error) {
+	switch name {
+	case "":
+		return NewChain(profile), nil
+	case "env":
+		return &Env{}, nil
+	case "webIdentity":
+		return &WebIdentity{}, nil
+	case "shared":
+		return &SharedFile{Profile: profile}, nil
+	case "container":
+		return &Container{}, nil
+	case "imds":
+		return &IMDS{}, nil
+	}
+	return nil, fmt.Errorf("unknown credentials provider %q", name)
+}
+
+// Chain tries its providers in order and sticks to the first one that succeeds.
+type Chain struct {
+	providers []Provider
+	selected  Provider
+}
+
+// NewChain returns the chain the AWS SDKs follow: environment variables, web identity token,
+// shared credentials and config files, container endpoint and finally EC2 instance metadata.
+func NewChain(profile string) *Chain {
+	return &Chain{providers: []Provider{&Env{}, &WebIdentity{}, &SharedFile{Profile: profile}, &Container{}, &IMDS{}}}
+}
+
+func (chain *Chain) Retrieve() (*Credentials, error) {
+	if chain.selected != nil {
+		return chain.selected.Retrieve()
+	}
+	var errs []string
+	for _, provider := range chain.providers {
+		creds, err := provider.Retrieve()
+		if err == nil {
+			chain.selected = provider
+			return creds, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return nil, errors.New("no credentials found: " + strings.Join(errs, "; "))
+}
@@ -0,0 +1,200 @@
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSharedFile(t *testing.T) {
+	dir := t.TempDir()
+	credentialsFile := filepath.Join(dir, "credentials")
+	configFile := filepath.Join(dir, "config")
+	_ = os.WriteFile(credentialsFile, []byte(`
+[default]
+aws_access_key_id = DEFAULT_KEY
+aws_secret_access_key = DEFAULT_SECRET
+
+# comment
+[dev]
+aws_access_key_id=DEV_KEY
+aws_secret_access_key=DEV_SECRET
+aws_session_token=DEV_TOKEN
+`), 0600)
+	_ = os.WriteFile(configFile, []byte(`
+[profile  ops]
+region = us-west-2
+aws_access_key_id = OPS_KEY
+aws_secret_access_key = OPS_SECRET
+`), 0600)
+
+	testCases := []struct {
+		profile  string
+		expected Credentials
+	}{
+		{profile: "", expected: Credentials{AccessKeyId: "DEFAULT_KEY", AccessSecretKey: "DEFAULT_SECRET"}},
+		{profile: "dev", expected: Credentials{AccessKeyId: "DEV_KEY", AccessSecretKey: "DEV_SECRET", SecurityToken: "DEV_TOKEN"}},
+		{profile: "ops", expected: Credentials{AccessKeyId: "OPS_KEY", AccessSecretKey: "OPS_SECRET"}},
+	}
+
+	t.Setenv("AWS_PROFILE", "")
+	for _, tt := range testCases {
+		shared := &SharedFile{Profile: tt.profile, CredentialsFile: credentialsFile, ConfigFile: configFile}
+		creds, err := shared.Retrieve()
+		if err != nil || *creds != tt.expected {
+			t.Errorf("profile %q: expected %+v, found %+v, %v", tt.profile, tt.expected, creds, err)
+		}
+	}
+
+	shared := &SharedFile{Profile: "missing", CredentialsFile: credentialsFile, ConfigFile: configFile}
+	if creds, err := shared.Retrieve(); err == nil {
+		t.Errorf("missing profile: expected an error, found %+v", creds)
+	}
+}
+
+func TestContainer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "TOKEN" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = rw.Write([]byte(`{"AccessKeyId":"KEY","SecretAccessKey":"SECRET","Token":"SESSION","Expiration":"2030-01-01T00:00:00Z"}`))
+	}))
+	defer server.Close()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	_ = os.WriteFile(tokenFile, []byte("TOKEN\n"), 0600)
+
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "")
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", server.URL+"/credentials")
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "")
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE", tokenFile)
+	creds, err := (&Container{}).Retrieve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyId != "KEY" || creds.AccessSecretKey != "SECRET" || creds.SecurityToken != "SESSION" || creds.Expiration.IsZero() {
+		t.Errorf("unexpected credentials %+v", creds)
+	}
+
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", "http://example.com/credentials")
+	if _, err = (&Container{}).Retrieve(); err == nil {
+		t.Error("expected plain HTTP to a remote host to be refused")
+	}
+}
+
+func TestChain(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", "")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "missing"))
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "missing"))
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "")
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", "")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	chain := NewChain("")
+	if creds, err := chain.Retrieve(); err == nil {
+		t.Fatalf("expected no credentials, found %+v", creds)
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "KEY")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "SECRET")
+	creds, err := chain.Retrieve()
+	if err != nil || creds.AccessKeyId != "KEY" {
+		t.Errorf("expected the environment credentials, found %+v, %v", creds, err)
+	}
+	if _, ok := chain.selected.(*Env); !ok {
+		t.Errorf("expected the chain to stick to the environment, found %T", chain.selected)
+	}
+}
+
+type blockingProvider struct {
+	release chan struct{}
+}
+
+func (provider *blockingProvider) Retrieve() (*Credentials, error) {
+	<-provider.release
+	return &Credentials{AccessKeyId: "KEY", AccessSecretKey: "SECRET"}, nil
+}
+
+func TestStore(t *testing.T) {
+	provider := &blockingProvider{release: make(chan struct{})}
+	store := NewStore(provider, 0)
+	if creds, err := store.Get(10 * time.Millisecond); !errors.Is(err, ErrNotReady) {
+		t.Fatalf("expected ErrNotReady, found %+v, %v", creds, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			creds, err := store.Get(5 * time.Second)
+			if err != nil || creds.AccessKeyId != "KEY" || creds.AccessSecretKey != "SECRET" {
+				t.Errorf("expected the credentials once retrieved, found %+v, %v", creds, err)
+			}
+		}()
+	}
+	close(provider.release)
+	wg.Wait()
+}
+
+type sequenceProvider struct {
+	mu        sync.Mutex
+	retrieved int
+	lifetime  time.Duration
+}
+
+func (provider *sequenceProvider) Retrieve() (*Credentials, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	provider.retrieved++
+	return &Credentials{AccessKeyId: fmt.Sprintf("KEY%d", provider.retrieved), Expiration: time.Now().Add(provider.lifetime)}, nil
+}
+
+func TestStoreRenew(t *testing.T) {
+	provider := &sequenceProvider{lifetime: time.Hour}
+	store := NewStore(provider, 0)
+	stale, err := store.Get(time.Second)
+	if err != nil || stale.AccessKeyId != "KEY1" {
+		t.Fatalf("expected the first credentials, found %+v, %v", stale, err)
+	}
+	fresh, err := store.Renew(stale, time.Second)
+	if err != nil || fresh.AccessKeyId != "KEY2" {
+		t.Fatalf("expected renewed credentials, found %+v, %v", fresh, err)
+	}
+	// Renewed already, by another request.
+	if creds, err := store.Renew(stale, time.Second); err != nil || creds != fresh {
+		t.Errorf("expected the renewed credentials, found %+v, %v", creds, err)
+	}
+}
+
+func TestStoreRefreshWindow(t *testing.T) {
+	// Within the refresh window as soon as retrieved, renewed at half their lifetime.
+	provider := &sequenceProvider{lifetime: 200 * time.Millisecond}
+	store := NewStore(provider, time.Minute)
+	first, err := store.Get(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	creds, err := store.Get(time.Second)
+	if err != nil || creds == first {
+		t.Errorf("expected expired credentials to be renewed, found %+v, %v", creds, err)
+	}
+}
+
+func TestRetryInterval(t *testing.T) {
+	for failures := 0; failures < 100; failures++ {
+		interval := retryInterval(failures)
+		if interval < minRetryInterval/2 || interval > maxRetryInterval {
+			t.Errorf("failure %d: interval %s out of bounds", failures, interval)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package credentials
+
+import (
+	"errors"
+	"os"
+)
+
+// Env reads credentials from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+// https://docs.aws.amazon.com/sdkref/latest/guide/feature-static-credentials.html
+type Env struct{}
+
+func (*Env) Retrieve() (*Credentials, error) {
+	creds := &Credentials{
+		AccessKeyId:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		AccessSecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SecurityToken:   os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyId == "" || creds.AccessSecretKey == "" {
+		return nil, errors.New("environment credentials: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are not set")
+	}
+	return creds, nil
+}
@@ -0,0 +1,80 @@
+package credentials
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	imdsEndpoint = "http://169.254.169.254"
+	imdsTokenTTL = "21600"
+)
+
+// IMDS retrieves the credentials of the role attached to the EC2 instance from the instance
+// metadata service, using IMDSv2 session tokens. AWS_EC2_METADATA_SERVICE_ENDPOINT overrides
+// the endpoint and AWS_EC2_METADATA_DISABLED=true disables the provider.
+// https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/instancedata-data-retrieval.html
+type IMDS struct {
+	client *http.Client
+}
+
+func (imds *IMDS) Retrieve() (*Credentials, error) {
+	if strings.EqualFold(os.Getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		return nil, errors.New("instance metadata credentials: disabled by AWS_EC2_METADATA_DISABLED")
+	}
+	if imds.client == nil {
+		// The metadata service answers quickly, if at all; off EC2 the chain should move on.
+		imds.client = &http.Client{Timeout: time.Second}
+	}
+	endpoint := strings.TrimSuffix(firstNonEmpty(os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT"), imdsEndpoint), "/")
+
+	token, err := imds.fetch(http.MethodPut, endpoint+"/latest/api/token", "X-aws-ec2-metadata-token-ttl-seconds", imdsTokenTTL)
+	if err != nil {
+		return nil, fmt.Errorf("instance metadata credentials: %w", err)
+	}
+	rolesUri := endpoint + "/latest/meta-data/iam/security-credentials/"
+	roles, err := imds.fetch(http.MethodGet, rolesUri, "X-aws-ec2-metadata-token", token)
+	if err != nil {
+		return nil, fmt.Errorf("instance metadata credentials: %w", err)
+	}
+	role := strings.TrimSpace(strings.SplitN(roles, "\n", 2)[0])
+	if role == "" {
+		return nil, errors.New("instance metadata credentials: no role attached to the instance")
+	}
+	body, err := imds.fetch(http.MethodGet, rolesUri+role, "X-aws-ec2-metadata-token", token)
+	if err != nil {
+		return nil, fmt.Errorf("instance metadata credentials: %w", err)
+	}
+	creds := &Credentials{}
+	if err = json.Unmarshal([]byte(body), creds); err != nil {
+		return nil, fmt.Errorf("instance metadata credentials: %w", err)
+	}
+	return creds, nil
+}
+
+func (imds *IMDS) fetch(httpMethod string, uri string, header string, value string) (string, error) {
+	req, err := http.NewRequest(httpMethod, uri, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(header, value)
+	resp, err := imds.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s %s responded with %s", httpMethod, uri, resp.Status)
+	}
+	return string(body), nil
+}
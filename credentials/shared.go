@@ -0,0 +1,134 @@
+package credentials
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SharedFile reads the static credentials of a profile from the shared credentials file,
+// ~/.aws/credentials, then from the shared config file, ~/.aws/config. Their locations and
+// the profile follow AWS_SHARED_CREDENTIALS_FILE, AWS_CONFIG_FILE and AWS_PROFILE unless set.
+// https://docs.aws.amazon.com/sdkref/latest/guide/file-format.html
+type SharedFile struct {
+	Profile         string
+	CredentialsFile string
+	ConfigFile      string
+}
+
+func (shared *SharedFile) Retrieve() (*Credentials, error) {
+	profile := firstNonEmpty(shared.Profile, os.Getenv("AWS_PROFILE"), "default")
+	credentialsFile := firstNonEmpty(shared.CredentialsFile, os.Getenv("AWS_SHARED_CREDENTIALS_FILE"), awsFile("credentials"))
+	configFile := firstNonEmpty(shared.ConfigFile, os.Getenv("AWS_CONFIG_FILE"), awsFile("config"))
+
+	// Profiles of the config file are prefixed, except for the default one.
+	configSection := "profile " + profile
+	if profile == "default" {
+		configSection = profile
+	}
+	for _, source := range []struct{ file, section string }{{credentialsFile, profile}, {configFile, configSection}} {
+		if source.file == "" {
+			continue
+		}
+		properties, err := readSection(source.file, source.section)
+		if err != nil {
+			return nil, fmt.Errorf("shared credentials: %w", err)
+		}
+		if properties["aws_access_key_id"] != "" && properties["aws_secret_access_key"] != "" {
+			return &Credentials{
+				AccessKeyId:     properties["aws_access_key_id"],
+				AccessSecretKey: properties["aws_secret_access_key"],
+				SecurityToken:   properties["aws_session_token"],
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("shared credentials: no access keys for profile %q", profile)
+}
+
+func awsFile(name string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".aws", name)
+}
+
+// ReadKeys returns the secret access keys of a file in the format of the shared credentials
+// file, by access key id: each profile holds an aws_access_key_id and aws_secret_access_key.
+func ReadKeys(path string) (map[string]string, error) {
+	sections, err := readSections(path)
+	if err != nil {
+		return nil, fmt.Errorf("access keys: %w", err)
+	}
+	keys := map[string]string{}
+	for section, properties := range sections {
+		accessKeyId, secretKey := properties["aws_access_key_id"], properties["aws_secret_access_key"]
+		if accessKeyId == "" || secretKey == "" {
+			return nil, fmt.Errorf("access keys: profile %q of %s lacks an access key id or secret", section, path)
+		}
+		keys[accessKeyId] = secretKey
+	}
+	return keys, nil
+}
+
+// readSection returns the properties of a section of an INI file, nothing if the file does not exist.
+func readSection(path string, section string) (map[string]string, error) {
+	sections, err := readSections(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if properties, ok := sections[section]; ok {
+		return properties, nil
+	}
+	return map[string]string{}, nil
+}
+
+// readSections returns the properties of each section of an INI file, by section name.
+func readSections(path string) (map[string]map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sections := map[string]map[string]string{}
+	var properties map[string]string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section := strings.Join(strings.Fields(line[1:len(line)-1]), " ")
+			if properties = sections[section]; properties == nil {
+				properties = map[string]string{}
+				sections[section] = properties
+			}
+			continue
+		}
+		if properties == nil {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			properties[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		}
+	}
+	return sections, scanner.Err()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,169 @@
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/log"
+)
+
+const (
+	// DefaultRefreshWindow is how long before their expiration credentials are renewed by default.
+	DefaultRefreshWindow = 5 * time.Minute
+	// Retries of failed retrievals back off exponentially between these bounds.
+	minRetryInterval = time.Second
+	maxRetryInterval = time.Minute
+)
+
+// ErrNotReady is returned when no valid credentials were retrieved in time.
+var ErrNotReady = errors.New("credentials are not available")
+
+// Store caches the credentials of a provider, which a background goroutine keeps up to date.
+// Each retrieval is stored as a new snapshot, swapped atomically, so that readers never see
+// credentials being updated.
+type Store struct {
+	provider      Provider
+	refreshWindow time.Duration
+	current       atomic.Value
+	refreshes     chan struct{}
+}
+
+// snapshot holds credentials until they are replaced, when next is closed. Temporary
+// credentials are due for renewal at renewAt.
+type snapshot struct {
+	creds   *Credentials
+	renewAt time.Time
+	next    chan struct{}
+}
+
+// NewStore returns a store and starts retrieving credentials from provider. Temporary
+// credentials are renewed at half their lifetime, or when they come within refreshWindow
+// of their expiration, whichever comes first.
+func NewStore(provider Provider, refreshWindow time.Duration) *Store {
+	if refreshWindow <= 0 {
+		refreshWindow = DefaultRefreshWindow
+	}
+	store := &Store{provider: provider, refreshWindow: refreshWindow, refreshes: make(chan struct{}, 1)}
+	store.current.Store(&snapshot{next: make(chan struct{})})
+	go store.refresh()
+	return store
+}
+
+// Get returns the current credentials, waiting up to timeout for the first ones to be retrieved,
+// or for expired ones to be renewed. The credentials returned are shared and must not be modified.
+func (store *Store) Get(timeout time.Duration) (*Credentials, error) {
+	return store.wait(timeout, func(creds *Credentials) bool { return true })
+}
+
+// Renew forces the renewal of stale credentials, rejected by AWS, and waits up to timeout for
+// others. Credentials renewed in the meantime are returned right away.
+func (store *Store) Renew(stale *Credentials, timeout time.Duration) (*Credentials, error) {
+	return store.wait(timeout, func(creds *Credentials) bool { return creds != stale })
+}
+
+func (store *Store) wait(timeout time.Duration, accept func(*Credentials) bool) (*Credentials, error) {
+	var deadline <-chan time.Time
+	for {
+		current := store.current.Load().(*snapshot)
+		if current.creds != nil {
+			expiration := current.creds.Expiration
+			valid := expiration.IsZero() || time.Now().Before(expiration)
+			if valid && accept(current.creds) {
+				if !current.renewAt.IsZero() && time.Now().After(current.renewAt) {
+					// The renewal is late, the host may have been suspended
+					store.Refresh()
+				}
+				return current.creds, nil
+			}
+			store.Refresh()
+		}
+		if deadline == nil {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+		select {
+		case <-current.next:
+		case <-deadline:
+			return nil, fmt.Errorf("%w after %s", ErrNotReady, timeout)
+		}
+	}
+}
+
+// Refresh asks for the credentials to be retrieved again, without waiting for them.
+func (store *Store) Refresh() {
+	select {
+	case store.refreshes <- struct{}{}:
+	default:
+		// A refresh is already pending
+	}
+}
+
+func (store *Store) set(creds *Credentials, renewAt time.Time) {
+	previous := store.current.Load().(*snapshot)
+	store.current.Store(&snapshot{creds: creds, renewAt: renewAt, next: make(chan struct{})})
+	close(previous.next)
+}
+
+func (store *Store) refresh() {
+	failures := 0
+	for {
+		fresh, err := store.provider.Retrieve()
+		if err != nil {
+			log.Error(err.Error())
+			// Forced refreshes are not honored while backing off, to spare the provider.
+			retryTimer := time.NewTimer(retryInterval(failures))
+			<-retryTimer.C
+			failures++
+			continue
+		}
+		failures = 0
+		// Refreshes asked for until now are served by these credentials.
+		select {
+		case <-store.refreshes:
+		default:
+		}
+		if fresh.Expiration.IsZero() {
+			// Long term credentials are only renewed when AWS rejects them
+			store.set(fresh, time.Time{})
+			<-store.refreshes
+			continue
+		}
+		renewAt := store.renewalTime(fresh.Expiration)
+		store.set(fresh, renewAt)
+		renewalTimer := time.NewTimer(time.Until(renewAt))
+		select {
+		case <-renewalTimer.C:
+		case <-store.refreshes:
+			renewalTimer.Stop()
+		}
+	}
+}
+
+// renewalTime is half the remaining lifetime of credentials expiring at expiration, or the
+// start of the refresh window if earlier. Credentials shorter lived than the window would
+// otherwise be renewed over and over.
+func (store *Store) renewalTime(expiration time.Time) time.Time {
+	lifetime := time.Until(expiration)
+	delay := lifetime / 2
+	if untilWindow := lifetime - store.refreshWindow; untilWindow > 0 && untilWindow < delay {
+		delay = untilWindow
+	}
+	return time.Now().Add(delay)
+}
+
+// retryInterval doubles with each failure, from minRetryInterval up to maxRetryInterval, with a
+// random half of it as jitter so that plugin instances do not retry in lockstep.
+func retryInterval(failures int) time.Duration {
+	interval := maxRetryInterval
+	if failures < 16 {
+		interval = minRetryInterval << failures
+		if interval > maxRetryInterval {
+			interval = maxRetryInterval
+		}
+	}
+	return interval/2 + time.Duration(rand.Int63n(int64(interval/2)+1))
+}
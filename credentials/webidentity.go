@@ -0,0 +1,93 @@
+package credentials
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultSessionName = "traefik-aws-plugin"
+
+// WebIdentity exchanges the token of AWS_WEB_IDENTITY_TOKEN_FILE for the credentials of
+// AWS_ROLE_ARN, the way IAM roles for service accounts (IRSA) work on EKS. The request to
+// STS is not signed, the token authenticates it.
+// https://docs.aws.amazon.com/STS/latest/APIReference/API_AssumeRoleWithWebIdentity.html
+type WebIdentity struct {
+	client *http.Client
+}
+
+// https://docs.aws.amazon.com/STS/latest/APIReference/API_AssumeRoleWithWebIdentity.html#API_AssumeRoleWithWebIdentity_ResponseElements
+type assumeRoleWithWebIdentityResponse struct {
+	Credentials struct {
+		AccessKeyId     string    `xml:"AccessKeyId"`
+		SecretAccessKey string    `xml:"SecretAccessKey"`
+		SessionToken    string    `xml:"SessionToken"`
+		Expiration      time.Time `xml:"Expiration"`
+	} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+}
+
+func (webIdentity *WebIdentity) Retrieve() (*Credentials, error) {
+	tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	roleArn := os.Getenv("AWS_ROLE_ARN")
+	if tokenFile == "" || roleArn == "" {
+		return nil, errors.New("web identity credentials: AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN are not set")
+	}
+	// Read on every retrieval, the token is rotated.
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("web identity credentials: %w", err)
+	}
+
+	form := url.Values{}
+	form.Set("Action", "AssumeRoleWithWebIdentity")
+	form.Set("Version", "2011-06-15")
+	form.Set("RoleArn", roleArn)
+	form.Set("RoleSessionName", firstNonEmpty(os.Getenv("AWS_ROLE_SESSION_NAME"), defaultSessionName))
+	form.Set("WebIdentityToken", strings.TrimSpace(string(token)))
+	req, err := http.NewRequest(http.MethodPost, StsEndpoint(firstNonEmpty(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"))), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if webIdentity.client == nil {
+		webIdentity.client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := webIdentity.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("web identity credentials: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("web identity credentials: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("web identity credentials: STS responded with %s: %s", resp.Status, body)
+	}
+	result := &assumeRoleWithWebIdentityResponse{}
+	if err = xml.Unmarshal(body, result); err != nil {
+		return nil, fmt.Errorf("web identity credentials: %w", err)
+	}
+	return &Credentials{
+		AccessKeyId:     result.Credentials.AccessKeyId,
+		AccessSecretKey: result.Credentials.SecretAccessKey,
+		SecurityToken:   result.Credentials.SessionToken,
+		RoleArn:         roleArn,
+		Expiration:      result.Credentials.Expiration,
+	}, nil
+}
+
+// StsEndpoint returns the regional STS endpoint, or the global one when region is empty.
+// https://docs.aws.amazon.com/general/latest/gr/sts.html
+func StsEndpoint(region string) string {
+	if region == "" {
+		return "https://sts.amazonaws.com/"
+	}
+	return "https://sts." + region + ".amazonaws.com/"
+}
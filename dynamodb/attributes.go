@@ -0,0 +1,113 @@
+package dynamodb
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// attributeValue is an attribute in the JSON of the DynamoDB API, tagged with its type:
+// {"S": "text"}, {"N": "42"}, {"M": {...}} and so on.
+// https://docs.aws.amazon.com/amazondynamodb/latest/APIReference/API_AttributeValue.html
+type attributeValue = map[string]interface{}
+
+// marshalAttribute turns a value of plain JSON, decoded with numbers kept as json.Number,
+// into its DynamoDB attribute: strings, numbers, booleans, null, lists and maps.
+func marshalAttribute(v interface{}) (attributeValue, error) {
+	switch value := v.(type) {
+	case nil:
+		return attributeValue{"NULL": true}, nil
+	case string:
+		return attributeValue{"S": value}, nil
+	case json.Number:
+		return attributeValue{"N": value.String()}, nil
+	case bool:
+		return attributeValue{"BOOL": value}, nil
+	case []interface{}:
+		list := make([]interface{}, len(value))
+		for i, element := range value {
+			attribute, err := marshalAttribute(element)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = attribute
+		}
+		return attributeValue{"L": list}, nil
+	case map[string]interface{}:
+		m, err := marshalItem(value)
+		if err != nil {
+			return nil, err
+		}
+		return attributeValue{"M": m}, nil
+	}
+	return nil, fmt.Errorf("unsupported value %v", v)
+}
+
+func marshalItem(item map[string]interface{}) (map[string]interface{}, error) {
+	attributes := make(map[string]interface{}, len(item))
+	for name, v := range item {
+		attribute, err := marshalAttribute(v)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %q: %w", name, err)
+		}
+		attributes[name] = attribute
+	}
+	return attributes, nil
+}
+
+// unmarshalAttribute turns a DynamoDB attribute back into plain JSON. Sets become lists,
+// binary values stay base64 encoded strings.
+func unmarshalAttribute(v interface{}) (interface{}, error) {
+	attribute, ok := v.(map[string]interface{})
+	if !ok || len(attribute) != 1 {
+		return nil, fmt.Errorf("invalid attribute value %v", v)
+	}
+	for dataType, value := range attribute {
+		switch dataType {
+		case "S", "B", "BOOL":
+			return value, nil
+		case "NULL":
+			return nil, nil
+		case "N":
+			number, _ := value.(string)
+			return json.Number(number), nil
+		case "SS", "BS":
+			return value, nil
+		case "NS":
+			numbers, _ := value.([]interface{})
+			list := make([]interface{}, len(numbers))
+			for i, number := range numbers {
+				s, _ := number.(string)
+				list[i] = json.Number(s)
+			}
+			return list, nil
+		case "L":
+			elements, _ := value.([]interface{})
+			list := make([]interface{}, len(elements))
+			for i, element := range elements {
+				decoded, err := unmarshalAttribute(element)
+				if err != nil {
+					return nil, err
+				}
+				list[i] = decoded
+			}
+			return list, nil
+		case "M":
+			m, _ := value.(map[string]interface{})
+			return unmarshalItem(m)
+		}
+		return nil, fmt.Errorf("unsupported attribute type %q", dataType)
+	}
+	return nil, nil
+}
+
+func unmarshalItem(attributes map[string]interface{}) (map[string]interface{}, error) {
+	item := make(map[string]interface{}, len(attributes))
+	for name, attribute := range attributes {
+		value, err := unmarshalAttribute(attribute)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %q: %w", name, err)
+		}
+		item[name] = value
+	}
+	return item, nil
+}
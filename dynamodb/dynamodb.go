@@ -0,0 +1,331 @@
+package dynamodb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/awsclient"
+	"github.com/bluecatengineering/traefik-aws-plugin/credentials"
+	"github.com/bluecatengineering/traefik-aws-plugin/log"
+	"github.com/bluecatengineering/traefik-aws-plugin/service"
+	"github.com/bluecatengineering/traefik-aws-plugin/signer"
+	"github.com/google/uuid"
+)
+
+// Operations are called with the JSON 1.0 protocol, named in X-Amz-Target.
+// https://docs.aws.amazon.com/amazondynamodb/latest/developerguide/Programming.LowLevelAPI.html
+const (
+	contentType  = "application/x-amz-json-1.0"
+	targetPrefix = "DynamoDB_20120810."
+)
+
+// maxItemSize is the largest item DynamoDB stores, attribute names included.
+const maxItemSize = 400 << 10
+
+// DynamoDB stores JSON documents as the items of a table. The path of a request is the value of
+// the partition key, or with a sort key, the partition key value, a slash, then the sort key value.
+// Both keys are strings. Every write sets the version attribute to a new UUID, which is the ETag
+// of the item for conditional requests.
+type DynamoDB struct {
+	client       *awsclient.Client
+	crTemplate   signer.CanonRequest
+	endpoint     string
+	table        string
+	partitionKey string
+	sortKey      string
+	version      string
+}
+
+// DefaultVersionAttribute holds the version of the items unless configured otherwise.
+const DefaultVersionAttribute = "version"
+
+// New returns the service for table, whose key attributes are partitionKey and, if not empty,
+// sortKey, and whose items have their version in versionAttribute, DefaultVersionAttribute if
+// empty. Requests go to the regional endpoint unless endpoint, such as the URL of DynamoDB
+// local, is set.
+func New(table, partitionKey, sortKey, versionAttribute, region string, timeoutSeconds int, endpoint string, creds *credentials.Store) (*DynamoDB, error) {
+	if table == "" || partitionKey == "" {
+		return nil, fmt.Errorf("a table and its partition key are required")
+	}
+	if versionAttribute == "" {
+		versionAttribute = DefaultVersionAttribute
+	}
+	if versionAttribute == partitionKey || versionAttribute == sortKey {
+		return nil, fmt.Errorf("the version attribute %q is a key attribute", versionAttribute)
+	}
+	if endpoint == "" {
+		if region == "" {
+			return nil, fmt.Errorf("a region or an endpoint is required")
+		}
+		endpoint = "https://dynamodb." + region + ".amazonaws.com/"
+	}
+	if region == "" {
+		// Local implementations accept any region.
+		region = "us-east-1"
+	}
+	return &DynamoDB{
+		client:       awsclient.New(protocol, timeoutSeconds, creds),
+		crTemplate:   signer.CanonRequest{Region: region, Service: "dynamodb"},
+		endpoint:     endpoint,
+		table:        table,
+		partitionKey: partitionKey,
+		sortKey:      sortKey,
+		version:      versionAttribute,
+	}, nil
+}
+
+// key returns the primary key of the item at name.
+func (db *DynamoDB) key(name string) (map[string]interface{}, error) {
+	if name == "" {
+		return nil, fmt.Errorf("%w: missing item key", service.ErrBadRequest)
+	}
+	if db.sortKey == "" {
+		return map[string]interface{}{db.partitionKey: attributeValue{"S": name}}, nil
+	}
+	partition, sort, ok := strings.Cut(name, "/")
+	if !ok || partition == "" || sort == "" {
+		return nil, fmt.Errorf("%w: %q is not <%s>/<%s>", service.ErrBadRequest, name, db.partitionKey, db.sortKey)
+	}
+	return map[string]interface{}{
+		db.partitionKey: attributeValue{"S": partition},
+		db.sortKey:      attributeValue{"S": sort},
+	}, nil
+}
+
+// Put stores the JSON object of payload as the item at name, replacing it entirely. The key
+// attributes are those of the path, whatever the object holds. If-Match and If-None-Match are
+// turned into a condition on the version of the item, DynamoDB rejecting the write when it
+// does not hold.
+// https://docs.aws.amazon.com/amazondynamodb/latest/APIReference/API_PutItem.html
+// https://docs.aws.amazon.com/amazondynamodb/latest/developerguide/Expressions.ConditionExpressions.html
+func (db *DynamoDB) Put(name string, payload io.Reader, _ int64, header http.Header, rw http.ResponseWriter) ([]byte, error) {
+	key, err := db.key(name)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(io.LimitReader(payload, maxItemSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxItemSize {
+		return nil, fmt.Errorf("%w: items are %d bytes at most", service.ErrTooLarge, maxItemSize)
+	}
+	var document map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err = decoder.Decode(&document); err != nil || document == nil {
+		return nil, fmt.Errorf("%w: the item must be a JSON object", service.ErrBadRequest)
+	}
+	item, err := marshalItem(document)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", service.ErrBadRequest, err)
+	}
+	for attribute, value := range key {
+		item[attribute] = value
+	}
+	version := uuid.NewString()
+	item[db.version] = attributeValue{"S": version}
+	input := map[string]interface{}{"TableName": db.table, "Item": item}
+	db.addCondition(input, header)
+	if err = db.call("PutItem", input, nil); err != nil {
+		return nil, err
+	}
+	log.Debug(fmt.Sprintf("%q written", name))
+	rw.Header().Add("Location", name)
+	rw.Header().Set("ETag", `"`+version+`"`)
+	return nil, nil
+}
+
+// addCondition adds the ConditionExpression of the If-Match and If-None-Match headers to the
+// input of a write: for If-Match, the item exists for *, has one of the versions listed
+// otherwise; for If-None-Match, the item does not exist for *, has none of the versions listed
+// otherwise.
+func (db *DynamoDB) addCondition(input map[string]interface{}, header http.Header) {
+	var conditions []string
+	names := map[string]string{}
+	values := map[string]interface{}{}
+	// versionIn lists the versions of etags as placeholders; DynamoDB rejects names and values
+	// the expression does not use, so that they are only set when needed.
+	versionIn := func(etags string, prefix string) string {
+		names["#version"] = db.version
+		var placeholders []string
+		for i, etag := range parseETags(etags) {
+			placeholder := fmt.Sprintf(":%s%d", prefix, i)
+			placeholders = append(placeholders, placeholder)
+			values[placeholder] = attributeValue{"S": etag}
+		}
+		return "#version IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+	if ifMatch := header.Get("If-Match"); ifMatch != "" {
+		if strings.TrimSpace(ifMatch) == "*" {
+			names["#pk"] = db.partitionKey
+			conditions = append(conditions, "attribute_exists(#pk)")
+		} else {
+			conditions = append(conditions, versionIn(ifMatch, "version"))
+		}
+	}
+	if ifNoneMatch := header.Get("If-None-Match"); ifNoneMatch != "" {
+		names["#pk"] = db.partitionKey
+		if strings.TrimSpace(ifNoneMatch) == "*" {
+			conditions = append(conditions, "attribute_not_exists(#pk)")
+		} else {
+			conditions = append(conditions, "(attribute_not_exists(#pk) OR NOT ("+versionIn(ifNoneMatch, "noneMatch")+"))")
+		}
+	}
+	if len(conditions) == 0 {
+		return
+	}
+	input["ConditionExpression"] = strings.Join(conditions, " AND ")
+	input["ExpressionAttributeNames"] = names
+	if len(values) > 0 {
+		input["ExpressionAttributeValues"] = values
+	}
+}
+
+// parseETags returns the versions of a list of entity tags, unquoted, weak or not.
+func parseETags(header string) []string {
+	var etags []string
+	for _, etag := range strings.Split(header, ",") {
+		etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+		etags = append(etags, strings.Trim(etag, `"`))
+	}
+	return etags
+}
+
+// etag is the entity tag of the version of a decoded item, empty when it has none.
+func (db *DynamoDB) etag(item map[string]interface{}) string {
+	if version, ok := item[db.version].(string); ok {
+		return `"` + version + `"`
+	}
+	return ""
+}
+
+// Post stores the item under a generated UUID: the sort key under the partition of the path,
+// or the partition key itself when there is no sort key.
+func (db *DynamoDB) Post(path string, payload io.Reader, contentLength int64, header http.Header, rw http.ResponseWriter) ([]byte, error) {
+	name := uuid.NewString()
+	if path != "" {
+		name = strings.TrimSuffix(path, "/") + "/" + name
+	}
+	return db.Put(name, payload, contentLength, header, rw)
+}
+
+// Get responds with the item as a JSON object, its version as ETag. Reads are strongly
+// consistent, so that an item is found as soon as it is written.
+// https://docs.aws.amazon.com/amazondynamodb/latest/APIReference/API_GetItem.html
+func (db *DynamoDB) Get(name string, req *http.Request, rw http.ResponseWriter) error {
+	document, err := db.getDocument(name, req.Header, rw)
+	if err != nil {
+		return err
+	}
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write(document)
+	return err
+}
+
+// Head sets the headers Get would respond with.
+func (db *DynamoDB) Head(name string, req *http.Request, rw http.ResponseWriter) error {
+	_, err := db.getDocument(name, req.Header, rw)
+	return err
+}
+
+// getDocument reads the item, checks the If-Match and If-None-Match headers of the request
+// against its version and sets the response headers.
+func (db *DynamoDB) getDocument(name string, header http.Header, rw http.ResponseWriter) ([]byte, error) {
+	item, err := db.getItem(name)
+	if err != nil {
+		return nil, err
+	}
+	etag := db.etag(item)
+	if ifMatch := header.Get("If-Match"); ifMatch != "" && !matchesETag(ifMatch, etag) {
+		return nil, fmt.Errorf("%w: %q", service.ErrPreconditionFailed, name)
+	}
+	if ifNoneMatch := header.Get("If-None-Match"); ifNoneMatch != "" && matchesETag(ifNoneMatch, etag) {
+		rw.Header().Set("ETag", etag)
+		return nil, fmt.Errorf("%w: %q", service.ErrNotModified, name)
+	}
+	document, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Content-Length", strconv.Itoa(len(document)))
+	if etag != "" {
+		rw.Header().Set("ETag", etag)
+	}
+	return document, nil
+}
+
+// matchesETag tells whether the entity tag is listed in an If-Match or If-None-Match header.
+func matchesETag(header string, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, version := range parseETags(header) {
+		if etag != "" && `"`+version+`"` == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *DynamoDB) getItem(name string) (map[string]interface{}, error) {
+	key, err := db.key(name)
+	if err != nil {
+		return nil, err
+	}
+	input := map[string]interface{}{"TableName": db.table, "Key": key, "ConsistentRead": true}
+	output := &struct {
+		Item map[string]interface{}
+	}{}
+	if err = db.call("GetItem", input, output); err != nil {
+		return nil, err
+	}
+	if output.Item == nil {
+		return nil, fmt.Errorf("%w: %q", service.ErrNotFound, name)
+	}
+	return unmarshalItem(output.Item)
+}
+
+// Delete removes the item. Like S3, it succeeds whether or not the item exists.
+// https://docs.aws.amazon.com/amazondynamodb/latest/APIReference/API_DeleteItem.html
+func (db *DynamoDB) Delete(name string, rw http.ResponseWriter) error {
+	key, err := db.key(name)
+	if err != nil {
+		return err
+	}
+	return db.call("DeleteItem", map[string]interface{}{"TableName": db.table, "Key": key}, nil)
+}
+
+// call sends an operation and decodes its response into output, unless nil. Numbers are kept
+// as json.Number, attribute values being strings anyway.
+func (db *DynamoDB) call(operation string, input interface{}, output interface{}) error {
+	payload, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	header.Set("X-Amz-Target", targetPrefix+operation)
+	body, err := db.client.Call(&awsclient.Call{
+		Action:       operation,
+		Endpoint:     db.endpoint,
+		Header:       header,
+		Payload:      payload,
+		CanonRequest: db.crTemplate,
+	})
+	if err != nil || output == nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err = decoder.Decode(output); err != nil {
+		return fmt.Errorf("%s: invalid response: %w", operation, err)
+	}
+	return nil
+}
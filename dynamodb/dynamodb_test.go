@@ -0,0 +1,376 @@
+package dynamodb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/awsclient"
+	"github.com/bluecatengineering/traefik-aws-plugin/credentials"
+	"github.com/bluecatengineering/traefik-aws-plugin/service"
+)
+
+// fakeDynamoDB is a minimal in-memory table keyed by pk and sk, speaking just enough of the
+// JSON 1.0 protocol for the tests.
+type fakeDynamoDB struct {
+	mu    sync.Mutex
+	items map[string]map[string]interface{}
+	// expired calls are rejected as signed with an expired token.
+	expired int
+}
+
+func (fake *fakeDynamoDB) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fail := func(status int, exception string) {
+		rw.WriteHeader(status)
+		_, _ = fmt.Fprintf(rw, `{"__type":"com.amazonaws.dynamodb.v20120810#%s","message":"failed"}`, exception)
+	}
+	if req.Header.Get("Content-Type") != contentType || !strings.Contains(req.Header.Get("Authorization"), "/us-east-1/dynamodb/aws4_request") {
+		fail(http.StatusBadRequest, "MissingAuthenticationTokenException")
+		return
+	}
+	if fake.expired > 0 {
+		fake.expired--
+		fail(http.StatusBadRequest, "ExpiredTokenException")
+		return
+	}
+	input := map[string]interface{}{}
+	decoder := json.NewDecoder(req.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&input); err != nil {
+		fail(http.StatusBadRequest, "SerializationException")
+		return
+	}
+	if input["TableName"] != "table" {
+		fail(http.StatusBadRequest, "ResourceNotFoundException")
+		return
+	}
+	if unused := unusedNames(input); unused != "" {
+		fail(http.StatusBadRequest, "ValidationException")
+		return
+	}
+	itemKey := func(item interface{}) string {
+		attributes, _ := item.(map[string]interface{})
+		return fmt.Sprint(attributes["pk"], attributes["sk"])
+	}
+	switch strings.TrimPrefix(req.Header.Get("X-Amz-Target"), targetPrefix) {
+	case "PutItem":
+		item, _ := input["Item"].(map[string]interface{})
+		if !fake.conditionHolds(input, fake.items[itemKey(item)]) {
+			fail(http.StatusBadRequest, "ConditionalCheckFailedException")
+			return
+		}
+		fake.items[itemKey(item)] = item
+		_, _ = rw.Write([]byte(`{}`))
+	case "Query":
+		_ = json.NewEncoder(rw).Encode(fake.query(input))
+	case "GetItem":
+		item, ok := fake.items[itemKey(input["Key"])]
+		if !ok {
+			_, _ = rw.Write([]byte(`{}`))
+			return
+		}
+		_ = json.NewEncoder(rw).Encode(map[string]interface{}{"Item": item})
+	case "DeleteItem":
+		delete(fake.items, itemKey(input["Key"]))
+		_, _ = rw.Write([]byte(`{}`))
+	default:
+		fail(http.StatusBadRequest, "UnknownOperationException")
+	}
+}
+
+// conditionHolds evaluates the conditions the service writes, on the existing item if any.
+func (fake *fakeDynamoDB) conditionHolds(input map[string]interface{}, existing map[string]interface{}) bool {
+	condition, _ := input["ConditionExpression"].(string)
+	values, _ := input["ExpressionAttributeValues"].(map[string]interface{})
+	if condition == "" {
+		return true
+	}
+	for _, term := range strings.Split(condition, " AND ") {
+		if !termHolds(term, values, existing) {
+			return false
+		}
+	}
+	return true
+}
+
+// termHolds evaluates attribute_exists(#pk), attribute_not_exists(#pk), #version IN (...),
+// NOT and a parenthesized OR of these.
+func termHolds(term string, values map[string]interface{}, existing map[string]interface{}) bool {
+	switch {
+	case strings.HasPrefix(term, "NOT "):
+		return !termHolds(strings.TrimSuffix(strings.TrimPrefix(term, "NOT ("), ")"), values, existing)
+	case strings.HasPrefix(term, "(") && strings.Contains(term, " OR "):
+		for _, alternative := range strings.SplitN(term[1:len(term)-1], " OR ", 2) {
+			if termHolds(alternative, values, existing) {
+				return true
+			}
+		}
+		return false
+	case term == "attribute_exists(#pk)":
+		return existing != nil
+	case term == "attribute_not_exists(#pk)":
+		return existing == nil
+	case strings.HasPrefix(term, "#version IN ("):
+		for _, placeholder := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(term, "#version IN ("), ")"), ", ") {
+			if existing != nil && fmt.Sprint(existing["version"]) == fmt.Sprint(values[placeholder]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unusedNames returns an ExpressionAttributeNames placeholder that no expression of the input
+// refers to, which DynamoDB rejects.
+func unusedNames(input map[string]interface{}) string {
+	names, _ := input["ExpressionAttributeNames"].(map[string]interface{})
+	condition, _ := input["ConditionExpression"].(string)
+	keyCondition, _ := input["KeyConditionExpression"].(string)
+	for name := range names {
+		if !strings.Contains(condition, name) && !strings.Contains(keyCondition, name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// query returns the items of the partition sorted by sort key, from the exclusive start key
+// and with the sort key prefix if any, a page of Limit items at most.
+func (fake *fakeDynamoDB) query(input map[string]interface{}) map[string]interface{} {
+	values, _ := input["ExpressionAttributeValues"].(map[string]interface{})
+	partition, prefix := fmt.Sprint(values[":pk"]), ""
+	if values[":prefix"] != nil {
+		prefix = values[":prefix"].(map[string]interface{})["S"].(string)
+	}
+	start := ""
+	if startKey, ok := input["ExclusiveStartKey"].(map[string]interface{}); ok {
+		start = startKey["sk"].(map[string]interface{})["S"].(string)
+	}
+	var items []map[string]interface{}
+	for _, item := range fake.items {
+		sortKey := item["sk"].(map[string]interface{})["S"].(string)
+		if fmt.Sprint(item["pk"]) == partition && strings.HasPrefix(sortKey, prefix) && sortKey > start {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return fmt.Sprint(items[i]["sk"]) < fmt.Sprint(items[j]["sk"])
+	})
+	output := map[string]interface{}{}
+	limit, _ := input["Limit"].(json.Number).Int64()
+	if len(items) > int(limit) {
+		items = items[:limit]
+		last := items[len(items)-1]
+		output["LastEvaluatedKey"] = map[string]interface{}{"pk": last["pk"], "sk": last["sk"]}
+	}
+	output["Items"] = items
+	return output
+}
+
+func newFakeDynamoDB(t *testing.T, table string, sortKey string) (*fakeDynamoDB, *DynamoDB) {
+	fake := &fakeDynamoDB{items: map[string]map[string]interface{}{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	db, err := New(table, "pk", sortKey, "", "us-east-1", 5, server.URL, credentials.NewStore(&credentials.Static{AccessKeyId: "KEY", AccessSecretKey: "SECRET"}, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, db
+}
+
+func TestPutGet(t *testing.T) {
+	fake, db := newFakeDynamoDB(t, "table", "sk")
+	document := `{"name":"ada","age":36,"tags":["math",null],"address":{"city":"London","current":false}}`
+	rec := httptest.NewRecorder()
+	if _, err := db.Put("users/ada", strings.NewReader(document), -1, http.Header{}, rec); err != nil {
+		t.Fatal(err)
+	}
+	etag := rec.Header().Get("ETag")
+	item := fake.items[`map[S:users] map[S:ada]`]
+	if version := fmt.Sprint(item["version"]); etag == "" || version != "map[S:"+strings.Trim(etag, `"`)+"]" {
+		t.Errorf("expected the version %s to be the ETag %s", version, etag)
+	}
+	delete(item, "version")
+	stored, _ := json.Marshal(item)
+	expected := `{"address":{"M":{"city":{"S":"London"},"current":{"BOOL":false}}},"age":{"N":"36"},"name":{"S":"ada"},"pk":{"S":"users"},"sk":{"S":"ada"},"tags":{"L":[{"S":"math"},{"NULL":true}]}}`
+	if string(stored) != expected {
+		t.Errorf("unexpected item\nexpected: %s\nfound:    %s", expected, stored)
+	}
+
+	if _, err := db.Put("users/ada", strings.NewReader(document), -1, http.Header{}, httptest.NewRecorder()); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	if err := db.Get("users/ada", httptest.NewRequest(http.MethodGet, "/users/ada", nil), rec); err != nil {
+		t.Fatal(err)
+	}
+	etag = rec.Header().Get("ETag")
+	if rec.Body.String() != `{"address":{"city":"London","current":false},"age":36,"name":"ada","pk":"users","sk":"ada","tags":["math",null],"version":`+etag+`}` {
+		t.Errorf("unexpected document %s", rec.Body.String())
+	}
+	if rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected content type %q", rec.Header().Get("Content-Type"))
+	}
+
+	if err := db.Delete("users/ada", httptest.NewRecorder()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Head("users/ada", httptest.NewRequest(http.MethodHead, "/users/ada", nil), httptest.NewRecorder()); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("expected ErrNotFound once deleted, found %v", err)
+	}
+}
+
+func TestPost(t *testing.T) {
+	fake, db := newFakeDynamoDB(t, "table", "")
+	rec := httptest.NewRecorder()
+	if _, err := db.Post("", strings.NewReader(`{"name":"ada"}`), -1, http.Header{}, rec); err != nil {
+		t.Fatal(err)
+	}
+	location := rec.Header().Get("Location")
+	if len(location) != 36 || len(fake.items) != 1 {
+		t.Fatalf("expected an item keyed by a UUID, found %q, %v", location, fake.items)
+	}
+	if err := db.Get(location, httptest.NewRequest(http.MethodGet, "/"+location, nil), httptest.NewRecorder()); err != nil {
+		t.Errorf("expected the item to be found at its location, found %v", err)
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	_, db := newFakeDynamoDB(t, "table", "sk")
+	testCases := []struct {
+		name     string
+		path     string
+		body     string
+		expected error
+	}{
+		{name: "missing sort key", path: "users", body: `{}`, expected: service.ErrBadRequest},
+		{name: "not an object", path: "users/ada", body: `["ada"]`, expected: service.ErrBadRequest},
+		{name: "not JSON", path: "users/ada", body: `ada`, expected: service.ErrBadRequest},
+		{name: "too large", path: "users/ada", body: `{"name":"` + strings.Repeat("a", maxItemSize) + `"}`, expected: service.ErrTooLarge},
+	}
+	for _, tt := range testCases {
+		if _, err := db.Put(tt.path, strings.NewReader(tt.body), -1, http.Header{}, httptest.NewRecorder()); !errors.Is(err, tt.expected) {
+			t.Errorf("%s: expected %v, found %v", tt.name, tt.expected, err)
+		}
+	}
+
+	_, db = newFakeDynamoDB(t, "missing", "")
+	err := db.Get("ada", httptest.NewRequest(http.MethodGet, "/ada", nil), httptest.NewRecorder())
+	var dynamoErr *awsclient.Error
+	if !errors.Is(err, service.ErrNotFound) || !errors.As(err, &dynamoErr) || dynamoErr.Code != "ResourceNotFoundException" {
+		t.Errorf("expected a missing table to be reported, found %v", err)
+	}
+}
+
+func TestExpiredToken(t *testing.T) {
+	fake, db := newFakeDynamoDB(t, "table", "")
+	fake.expired = 1
+	if _, err := db.Put("ada", strings.NewReader(`{}`), -1, http.Header{}, httptest.NewRecorder()); err != nil {
+		t.Errorf("expected the call to be retried with renewed credentials, found %v", err)
+	}
+}
+
+func TestConditionalPut(t *testing.T) {
+	_, db := newFakeDynamoDB(t, "table", "sk")
+	put := func(ifMatch string, ifNoneMatch string) (string, error) {
+		header := http.Header{}
+		if ifMatch != "" {
+			header.Set("If-Match", ifMatch)
+		}
+		if ifNoneMatch != "" {
+			header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		_, err := db.Put("users/ada", strings.NewReader(`{"name":"ada"}`), -1, header, rec)
+		return rec.Header().Get("ETag"), err
+	}
+
+	if _, err := put("*", ""); !errors.Is(err, service.ErrPreconditionFailed) {
+		t.Errorf("If-Match *: expected a missing item to fail the precondition, found %v", err)
+	}
+	first, err := put("", "*")
+	if err != nil {
+		t.Fatalf("If-None-Match *: expected the item to be created, found %v", err)
+	}
+	if _, err = put("", "*"); !errors.Is(err, service.ErrPreconditionFailed) {
+		t.Errorf("If-None-Match *: expected an existing item to fail the precondition, found %v", err)
+	}
+	second, err := put(first, "")
+	if err != nil || second == first {
+		t.Fatalf("If-Match: expected the item to be updated to a new version, found %s, %v", second, err)
+	}
+	if _, err = put(first, ""); !errors.Is(err, service.ErrPreconditionFailed) {
+		t.Errorf("If-Match: expected a stale version to fail the precondition, found %v", err)
+	}
+	third, err := put(`W/"other", `+second, "")
+	if err != nil {
+		t.Errorf("If-Match: expected any of the listed versions to match, found %v", err)
+	}
+	if _, err = put("", `"other", `+third); !errors.Is(err, service.ErrPreconditionFailed) {
+		t.Errorf("If-None-Match: expected the current version to fail the precondition, found %v", err)
+	}
+	if _, err = put("", first+", "+second); err != nil {
+		t.Errorf("If-None-Match: expected stale versions to let the write through, found %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/users/ada", nil)
+	req.Header.Set("If-Match", first)
+	if err = db.Get("users/ada", req, rec); !errors.Is(err, service.ErrPreconditionFailed) {
+		t.Errorf("GET If-Match: expected a stale version to fail the precondition, found %v", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	_, db := newFakeDynamoDB(t, "table", "sk")
+	for _, name := range []string{"users/ada", "users/alan", "users/grace", "users/linus", "teams/math"} {
+		if _, err := db.Put(name, strings.NewReader(`{"name":"`+name+`"}`), -1, http.Header{}, httptest.NewRecorder()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var keys []string
+	token := ""
+	for page := 0; page < 10; page++ {
+		listing, err := db.List(service.ListOptions{Prefix: "users/", ContinuationToken: token, MaxKeys: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(listing.Items) != len(listing.Contents) || listing.KeyCount != len(listing.Contents) {
+			t.Fatalf("expected an item per key, found %+v", listing)
+		}
+		for i, object := range listing.Contents {
+			keys = append(keys, object.Key)
+			if !strings.Contains(string(listing.Items[i]), object.Key) || object.ETag == "" {
+				t.Errorf("unexpected item %s for %+v", listing.Items[i], object)
+			}
+		}
+		if !listing.IsTruncated {
+			break
+		}
+		token = listing.NextContinuationToken
+		if _, err = url.ParseQuery("continuation-token=" + token); err != nil {
+			t.Errorf("expected a token fit for a query string, found %q", token)
+		}
+	}
+	if strings.Join(keys, ",") != "users/ada,users/alan,users/grace,users/linus" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+
+	listing, err := db.List(service.ListOptions{Prefix: "users/a", StartAfter: "users/ada", MaxKeys: service.MaxKeys})
+	if err != nil || len(listing.Contents) != 1 || listing.Contents[0].Key != "users/alan" {
+		t.Errorf("expected the keys with the sort key prefix after start-after, found %+v, %v", listing, err)
+	}
+	if _, err = db.List(service.ListOptions{Prefix: "users/", ContinuationToken: "not a token", MaxKeys: 1}); !errors.Is(err, service.ErrBadRequest) {
+		t.Errorf("expected an invalid token to be rejected, found %v", err)
+	}
+}
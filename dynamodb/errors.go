@@ -0,0 +1,26 @@
+package dynamodb
+
+import (
+	"github.com/bluecatengineering/traefik-aws-plugin/awsclient"
+	"github.com/bluecatengineering/traefik-aws-plugin/service"
+)
+
+// By the name of their exception, without the namespace of __type.
+var errorCodes = map[string]error{
+	"ResourceNotFoundException":                service.ErrNotFound,
+	"AccessDeniedException":                    service.ErrAccessDenied,
+	"MissingAuthenticationTokenException":      service.ErrAccessDenied,
+	"ConditionalCheckFailedException":          service.ErrPreconditionFailed,
+	"TransactionConflictException":             service.ErrPreconditionFailed,
+	"ProvisionedThroughputExceededException":   service.ErrThrottled,
+	"RequestLimitExceeded":                     service.ErrThrottled,
+	"ThrottlingException":                      service.ErrThrottled,
+	"LimitExceededException":                   service.ErrThrottled,
+	"ItemCollectionSizeLimitExceededException": service.ErrTooLarge,
+	"ValidationException":                      service.ErrBadRequest,
+	"SerializationException":                   service.ErrBadRequest,
+}
+
+// Errors come in JSON, named after their exception in __type.
+// https://docs.aws.amazon.com/amazondynamodb/latest/developerguide/Programming.Errors.html
+var protocol = awsclient.Protocol{Service: "DynamoDB", ErrorCodes: errorCodes, DecodeError: awsclient.DecodeJSONError}
@@ -0,0 +1,117 @@
+package dynamodb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/service"
+)
+
+// List queries the items of the partition of the prefix, <partition>/ or <partition>/<sort key
+// prefix>, in the order of their sort key. The continuation token encodes the last evaluated
+// key of the query. There are no common prefixes, the delimiter is not applied.
+// https://docs.aws.amazon.com/amazondynamodb/latest/APIReference/API_Query.html
+func (db *DynamoDB) List(options service.ListOptions) (*service.Listing, error) {
+	if db.sortKey == "" {
+		return nil, fmt.Errorf("%w: listing items requires a sort key", service.ErrNotImplemented)
+	}
+	partition, sortPrefix, _ := strings.Cut(options.Prefix, "/")
+	if partition == "" {
+		return nil, fmt.Errorf("%w: listing items requires a partition", service.ErrBadRequest)
+	}
+	listing := &service.Listing{
+		Prefix:            options.Prefix,
+		StartAfter:        options.StartAfter,
+		MaxKeys:           options.MaxKeys,
+		ContinuationToken: options.ContinuationToken,
+	}
+	if options.MaxKeys == 0 {
+		return listing, nil
+	}
+
+	input := map[string]interface{}{
+		"TableName":                 db.table,
+		"KeyConditionExpression":    "#pk = :pk",
+		"ExpressionAttributeNames":  map[string]string{"#pk": db.partitionKey},
+		"ExpressionAttributeValues": map[string]interface{}{":pk": attributeValue{"S": partition}},
+		"ConsistentRead":            true,
+		"Limit":                     options.MaxKeys,
+	}
+	if sortPrefix != "" {
+		input["KeyConditionExpression"] = "#pk = :pk AND begins_with(#sk, :prefix)"
+		input["ExpressionAttributeNames"] = map[string]string{"#pk": db.partitionKey, "#sk": db.sortKey}
+		input["ExpressionAttributeValues"] = map[string]interface{}{
+			":pk":     attributeValue{"S": partition},
+			":prefix": attributeValue{"S": sortPrefix},
+		}
+	}
+	switch {
+	case options.ContinuationToken != "":
+		startKey, err := decodeStartKey(options.ContinuationToken)
+		if err != nil {
+			return nil, err
+		}
+		input["ExclusiveStartKey"] = startKey
+	case options.StartAfter != "":
+		startPartition, startSort, ok := strings.Cut(options.StartAfter, "/")
+		if !ok || startPartition != partition {
+			return nil, fmt.Errorf("%w: start-after %q is not in the partition %q", service.ErrBadRequest, options.StartAfter, partition)
+		}
+		input["ExclusiveStartKey"] = map[string]interface{}{
+			db.partitionKey: attributeValue{"S": partition},
+			db.sortKey:      attributeValue{"S": startSort},
+		}
+	}
+
+	output := &struct {
+		Items            []map[string]interface{}
+		LastEvaluatedKey map[string]interface{}
+	}{}
+	if err := db.call("Query", input, output); err != nil {
+		return nil, err
+	}
+	for _, attributes := range output.Items {
+		item, err := unmarshalItem(attributes)
+		if err != nil {
+			return nil, err
+		}
+		document, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		sort, _ := item[db.sortKey].(string)
+		listing.Contents = append(listing.Contents, service.Object{
+			Key:  partition + "/" + sort,
+			Size: int64(len(document)),
+			ETag: db.etag(item),
+		})
+		listing.Items = append(listing.Items, document)
+	}
+	listing.KeyCount = len(listing.Contents)
+	if output.LastEvaluatedKey != nil {
+		token, err := json.Marshal(output.LastEvaluatedKey)
+		if err != nil {
+			return nil, err
+		}
+		listing.IsTruncated = true
+		listing.NextContinuationToken = base64.RawURLEncoding.EncodeToString(token)
+	}
+	return listing, nil
+}
+
+func decodeStartKey(token string) (map[string]interface{}, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid continuation token", service.ErrBadRequest)
+	}
+	var startKey map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(decoded))
+	decoder.UseNumber()
+	if err = decoder.Decode(&startKey); err != nil || startKey == nil {
+		return nil, fmt.Errorf("%w: invalid continuation token", service.ErrBadRequest)
+	}
+	return startKey, nil
+}
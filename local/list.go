@@ -0,0 +1,128 @@
+package local
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/log"
+	"github.com/bluecatengineering/traefik-aws-plugin/service"
+)
+
+// List walks the directory for the files under the prefix, leaving out metadata and uploads
+// in progress, and pages them the way S3 does. The continuation token encodes the last key
+// or common prefix returned.
+func (local *Local) List(options service.ListOptions) (*service.Listing, error) {
+	marker := options.StartAfter
+	if options.ContinuationToken != "" {
+		token, err := base64.RawURLEncoding.DecodeString(options.ContinuationToken)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid continuation token", service.ErrBadRequest)
+		}
+		marker = string(token)
+	}
+	keys, err := local.keys(options.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	listing := &service.Listing{
+		Prefix:            options.Prefix,
+		Delimiter:         options.Delimiter,
+		StartAfter:        options.StartAfter,
+		MaxKeys:           options.MaxKeys,
+		ContinuationToken: options.ContinuationToken,
+	}
+	last := ""
+	for _, key := range keys {
+		if key.name <= marker || (options.Delimiter != "" && strings.HasSuffix(marker, options.Delimiter) && strings.HasPrefix(key.name, marker)) {
+			continue
+		}
+		commonPrefix := ""
+		if options.Delimiter != "" {
+			if i := strings.Index(key.name[len(options.Prefix):], options.Delimiter); i >= 0 {
+				commonPrefix = key.name[:len(options.Prefix)+i+len(options.Delimiter)]
+			}
+		}
+		if commonPrefix != "" && commonPrefix == last {
+			continue
+		}
+		if listing.KeyCount == options.MaxKeys {
+			listing.IsTruncated = true
+			listing.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+			break
+		}
+		listing.KeyCount++
+		if commonPrefix != "" {
+			listing.CommonPrefixes = append(listing.CommonPrefixes, service.CommonPrefix{Prefix: commonPrefix})
+			last = commonPrefix
+			continue
+		}
+		meta, err := local.readMetadata(key.name)
+		if err != nil {
+			log.Error(err.Error())
+			return nil, err
+		}
+		listing.Contents = append(listing.Contents, service.Object{
+			Key:          key.name,
+			Size:         key.info.Size(),
+			ETag:         meta.ETag,
+			LastModified: key.info.ModTime().UTC(),
+		})
+		last = key.name
+	}
+	return listing, nil
+}
+
+type localKey struct {
+	name string
+	info fs.FileInfo
+}
+
+// keys returns the files whose names start with prefix, sorted by name. Only the directory
+// holding the prefix is walked.
+func (local *Local) keys(prefix string) ([]localKey, error) {
+	base := prefix[:strings.LastIndex(prefix, "/")+1]
+	root := filepath.Join(local.directory, filepath.FromSlash(base))
+	if rel, err := filepath.Rel(local.directory, root); err != nil || strings.HasPrefix(rel, "..") {
+		return nil, fmt.Errorf("%w: prefix %q is outside the directory", service.ErrBadRequest, prefix)
+	}
+	var keys []localKey
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		rel, err := filepath.Rel(local.directory, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if entry.IsDir() {
+			if name == metadataDirectory {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(entry.Name(), ".upload-") || !entry.Type().IsRegular() || !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		keys = append(keys, localKey{name: name, info: info})
+		return nil
+	})
+	if err != nil {
+		return nil, fileError(err)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].name < keys[j].name })
+	return keys, nil
+}
@@ -1,16 +1,27 @@
 package local
 
 import (
+	"crypto/md5"
+	"encoding"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
+	"io/fs"
 	"net/http"
 	"os"
+	"path/filepath"
 
 	"github.com/bluecatengineering/traefik-aws-plugin/log"
+	"github.com/bluecatengineering/traefik-aws-plugin/service"
 	"github.com/google/uuid"
 )
 
 type Local struct {
 	directory string
+	// Serializes the appends to each object with checking their preconditions.
+	locks fileLocks
 }
 
 func New(directory string) *Local {
@@ -19,34 +30,167 @@ func New(directory string) *Local {
 	}
 }
 
-func (local *Local) Put(name string, payload []byte, _ string, rw http.ResponseWriter) ([]byte, error) {
+// Put streams payload into a temporary file next to the target and appends it to the object,
+// creating it if need be, once the upload is complete: an interrupted upload never leaves a
+// partial object behind. The content type and an MD5 ETag of the whole object are stored as
+// metadata. If-Match and If-None-Match are checked against the stored ETag before appending.
+func (local *Local) Put(name string, payload io.Reader, _ int64, header http.Header, rw http.ResponseWriter) ([]byte, error) {
 	filePath := fmt.Sprintf("%s/%s", local.directory, name)
-	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	file, err := os.CreateTemp(filepath.Dir(filePath), ".upload-*")
+	if err != nil {
+		log.Error(err.Error())
+		return nil, fileError(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	_, err = io.Copy(file, payload)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
 	if err != nil {
 		log.Error(err.Error())
 		return nil, err
 	}
-	_, err = file.Write(payload)
+	etag, err := local.append(name, file, header)
 	if err != nil {
 		log.Error(err.Error())
 		return nil, err
 	}
 	log.Debug(fmt.Sprintf("%q written", filePath))
 	rw.Header().Add("Location", name)
+	rw.Header().Set("ETag", etag)
 	return []byte(fmt.Sprintf("%q written", filePath)), nil
 }
 
-func (local *Local) Post(path string, payload []byte, contentType string, rw http.ResponseWriter) ([]byte, error) {
-	return local.Put(path+"/"+uuid.NewString(), payload, contentType, rw)
+// append writes the upload at the end of the object, provided the preconditions in header hold,
+// and returns the ETag of the result. The MD5 of the object is carried on from the state stored
+// with its metadata, so that only the upload is hashed. Appends to an object are serialized so
+// that none is lost; readers may see an append in progress.
+func (local *Local) append(name string, upload io.Reader, header http.Header) (string, error) {
+	filePath := fmt.Sprintf("%s/%s", local.directory, name)
+	defer local.locks.lock(filePath)()
+	if err := local.checkWritePreconditions(name, header); err != nil {
+		return "", err
+	}
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return "", fileError(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	meta, err := local.readMetadata(name)
+	if err != nil {
+		return "", err
+	}
+	md5sum, err := resumeMD5(filePath, info.Size(), meta)
+	if err != nil {
+		return "", err
+	}
+	written, err := io.Copy(io.MultiWriter(file, md5sum), upload)
+	if err != nil {
+		// Leave the object as it was rather than with part of the upload.
+		file.Truncate(info.Size())
+		return "", err
+	}
+	if contentType := header.Get("Content-Type"); contentType != "" {
+		meta.ContentType = contentType
+	}
+	meta.ETag = fmt.Sprintf("%q", hex.EncodeToString(md5sum.Sum(nil)))
+	meta.Size = info.Size() + written
+	if meta.MD5State, err = md5sum.(encoding.BinaryMarshaler).MarshalBinary(); err != nil {
+		return "", err
+	}
+	return meta.ETag, local.writeMetadata(name, meta)
 }
 
-func (local *Local) Get(name string, _ http.ResponseWriter) ([]byte, error) {
+// resumeMD5 returns the MD5 of the first size bytes of the file, restored from the state
+// stored in its metadata when that state covers them. Files written before the state was
+// stored, or outside this plugin, are hashed once.
+func resumeMD5(filePath string, size int64, meta *metadata) (hash.Hash, error) {
+	md5sum := md5.New()
+	if size == 0 {
+		return md5sum, nil
+	}
+	if meta.Size == size && md5sum.(encoding.BinaryUnmarshaler).UnmarshalBinary(meta.MD5State) == nil {
+		return md5sum, nil
+	}
+	md5sum.Reset()
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err = io.CopyN(md5sum, file, size); err != nil {
+		return nil, err
+	}
+	return md5sum, nil
+}
+
+func (local *Local) Post(path string, payload io.Reader, contentLength int64, header http.Header, rw http.ResponseWriter) ([]byte, error) {
+	return local.Put(path+"/"+uuid.NewString(), payload, contentLength, header, rw)
+}
+
+// Get serves the file with http.ServeContent, which takes care of Range and conditional
+// requests, the latter based on the stored ETag and the modification time of the file.
+func (local *Local) Get(name string, req *http.Request, rw http.ResponseWriter) error {
 	filePath := fmt.Sprintf("%s/%s", local.directory, name)
-	payload, err := os.ReadFile(filePath)
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fileError(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
 	if err != nil {
 		log.Error(err.Error())
-		return nil, err
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%w: %q is a directory", service.ErrNotFound, filePath)
+	}
+	meta, err := local.readMetadata(name)
+	if err != nil {
+		log.Error(err.Error())
+		return err
 	}
+	setObjectHeaders(name, meta, rw)
+	http.ServeContent(rw, req, name, info.ModTime(), file)
 	log.Debug(fmt.Sprintf("%q read", filePath))
-	return payload, nil
+	return nil
+}
+
+func (local *Local) Delete(name string, _ http.ResponseWriter) error {
+	filePath := fmt.Sprintf("%s/%s", local.directory, name)
+	// Directories are not objects, only files can be deleted.
+	if info, err := os.Stat(filePath); err == nil && info.IsDir() {
+		return fmt.Errorf("%w: %q is a directory", service.ErrNotFound, filePath)
+	}
+	err := os.Remove(filePath)
+	if err != nil {
+		return fileError(err)
+	}
+	if err = local.removeMetadata(name); err != nil {
+		log.Error(err.Error())
+	}
+	log.Debug(fmt.Sprintf("%q deleted", filePath))
+	return nil
+}
+
+// Head responds with the headers Get would send, http.ServeContent leaves the body out.
+func (local *Local) Head(name string, req *http.Request, rw http.ResponseWriter) error {
+	return local.Get(name, req, rw)
+}
+
+// fileError wraps file system errors into the matching service error.
+func fileError(err error) error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("%w: %s", service.ErrNotFound, err.Error())
+	case errors.Is(err, fs.ErrPermission):
+		return fmt.Errorf("%w: %s", service.ErrAccessDenied, err.Error())
+	}
+	log.Error(err.Error())
+	return err
 }
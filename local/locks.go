@@ -0,0 +1,42 @@
+package local
+
+import "sync"
+
+// fileLocks serializes the writes to each file, letting writes to different files proceed
+// in parallel. A lock is only kept while it is held or waited for.
+type fileLocks struct {
+	mu    sync.Mutex
+	locks map[string]*fileLock
+}
+
+type fileLock struct {
+	sync.Mutex
+	// refs counts the holder and the waiters of the lock.
+	refs int
+}
+
+// lock waits for the lock of filePath and returns the function releasing it.
+func (locks *fileLocks) lock(filePath string) func() {
+	locks.mu.Lock()
+	if locks.locks == nil {
+		locks.locks = map[string]*fileLock{}
+	}
+	lock, ok := locks.locks[filePath]
+	if !ok {
+		lock = &fileLock{}
+		locks.locks[filePath] = lock
+	}
+	lock.refs++
+	locks.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		locks.mu.Lock()
+		defer locks.mu.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(locks.locks, filePath)
+		}
+	}
+}
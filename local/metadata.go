@@ -0,0 +1,79 @@
+package local
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Object metadata is kept out of the way of the objects, in a tree mirroring the directory.
+const metadataDirectory = ".metadata"
+
+// metadata is what S3 would store alongside an object and cannot be derived from the file itself.
+type metadata struct {
+	ContentType string `json:"contentType,omitempty"`
+	ETag        string `json:"etag,omitempty"`
+	// MD5State is the marshaled state of the MD5 of the first Size bytes of the object,
+	// from which the ETag is carried on when appending.
+	MD5State []byte `json:"md5State,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+}
+
+func (local *Local) metadataPath(name string) string {
+	return filepath.Join(local.directory, metadataDirectory, name+".json")
+}
+
+func (local *Local) writeMetadata(name string, meta *metadata) error {
+	metadataPath := local.metadataPath(name)
+	if err := os.MkdirAll(filepath.Dir(metadataPath), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(metadataPath, data, 0644)
+}
+
+// readMetadata returns empty metadata for files written before metadata was stored,
+// or directly in the directory.
+func (local *Local) readMetadata(name string) (*metadata, error) {
+	meta := &metadata{}
+	data, err := os.ReadFile(local.metadataPath(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return meta, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (local *Local) removeMetadata(name string) error {
+	err := os.Remove(local.metadataPath(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// setObjectHeaders sets the headers S3 would send for the object that are not derived from the file.
+func setObjectHeaders(name string, meta *metadata, rw http.ResponseWriter) {
+	contentType := meta.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(name))
+	}
+	if contentType != "" {
+		rw.Header().Set("Content-Type", contentType)
+	}
+	if meta.ETag != "" {
+		rw.Header().Set("ETag", meta.ETag)
+	}
+}
@@ -0,0 +1,62 @@
+package local
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/service"
+)
+
+// checkWritePreconditions evaluates the If-Match and If-None-Match headers of a write,
+// the way S3 conditional writes do. If-None-Match: * only lets new objects be created.
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/conditional-writes.html
+func (local *Local) checkWritePreconditions(name string, header http.Header) error {
+	ifMatch := header.Get("If-Match")
+	ifNoneMatch := header.Get("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		return nil
+	}
+	info, err := os.Stat(fmt.Sprintf("%s/%s", local.directory, name))
+	exists := err == nil && !info.IsDir()
+	etag := ""
+	if exists {
+		meta, err := local.readMetadata(name)
+		if err != nil {
+			return err
+		}
+		etag = meta.ETag
+	}
+	if ifMatch != "" && !(exists && etagMatches(ifMatch, etag, false)) {
+		return fmt.Errorf("%w: If-Match %s", service.ErrPreconditionFailed, ifMatch)
+	}
+	if ifNoneMatch != "" && exists && etagMatches(ifNoneMatch, etag, true) {
+		return fmt.Errorf("%w: If-None-Match %s", service.ErrPreconditionFailed, ifNoneMatch)
+	}
+	return nil
+}
+
+// etagMatches tells whether an existing object with the given ETag matches a list of
+// entity tags, using the weak comparison for If-None-Match and the strong one, under which
+// weak tags never match, for If-Match.
+// https://www.rfc-editor.org/rfc/rfc9110#section-8.8.3.2
+func etagMatches(list string, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if etag == "" {
+			continue
+		}
+		if weak {
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		} else if candidate == etag && !strings.HasPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
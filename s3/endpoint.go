@@ -0,0 +1,86 @@
+package s3
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/awsclient"
+)
+
+// Endpoint configures where requests are sent. URL is the base URL of an S3 compatible
+// service such as MinIO or a VPC interface endpoint; when empty, the global endpoint
+// s3.amazonaws.com is used, or s3.<region>.amazonaws.com if Regional is set.
+// With PathStyle, the bucket is the first segment of the path rather than a subdomain.
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/VirtualHosting.html
+type Endpoint struct {
+	URL       string
+	PathStyle bool
+	Regional  bool
+}
+
+func (endpoint Endpoint) bucketUri(bucket string, region string) (string, error) {
+	if strings.HasPrefix(bucket, "arn:") {
+		if endpoint.URL != "" || endpoint.PathStyle {
+			return "", fmt.Errorf("access point %q: custom and path style endpoints are not supported", bucket)
+		}
+		accessPoint, err := parseAccessPoint(bucket)
+		if err != nil {
+			return "", err
+		}
+		return "https://" + accessPoint.host, nil
+	}
+	base := endpoint.URL
+	if base == "" {
+		host := "s3.amazonaws.com"
+		if endpoint.Regional {
+			if region == "" {
+				return "", fmt.Errorf("a regional endpoint requires a region")
+			}
+			host = "s3." + region + ".amazonaws.com"
+		}
+		base = "https://" + host
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid endpoint %q: %w", base, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("invalid endpoint %q: scheme and host are required", base)
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	if endpoint.PathStyle {
+		return u.Scheme + "://" + u.Host + path + "/" + bucket, nil
+	}
+	return u.Scheme + "://" + bucket + "." + u.Host + path, nil
+}
+
+type accessPoint struct {
+	host        string
+	region      string
+	multiRegion bool
+}
+
+// parseAccessPoint resolves the host of an access point given as the bucket by its ARN:
+// arn:aws:s3:<region>:<account>:accesspoint/<name> in a single region, or
+// arn:aws:s3::<account>:accesspoint/<alias> for a multi-region access point, signed with SigV4a.
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/MultiRegionAccessPointRequests.html
+func parseAccessPoint(arn string) (*accessPoint, error) {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[2] != "s3" || parts[4] == "" || !strings.HasPrefix(parts[5], "accesspoint/") {
+		return nil, fmt.Errorf("invalid access point ARN %q", arn)
+	}
+	suffix, ok := awsclient.PartitionSuffixes[parts[1]]
+	if !ok {
+		return nil, fmt.Errorf("access point %q: unknown partition %q", arn, parts[1])
+	}
+	region, account := parts[3], parts[4]
+	name := strings.TrimPrefix(parts[5], "accesspoint/")
+	if name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("invalid access point ARN %q", arn)
+	}
+	if region == "" {
+		return &accessPoint{host: name + ".accesspoint.s3-global." + suffix, multiRegion: true}, nil
+	}
+	return &accessPoint{host: name + "-" + account + ".s3-accesspoint." + region + "." + suffix, region: region}, nil
+}
@@ -0,0 +1,101 @@
+package s3
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/service"
+)
+
+// Error responses are small, anything beyond is not worth reading.
+const maxErrorBody = 64 << 10
+
+// Error is an S3 error response. It unwraps to the matching service error, if any.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/ErrorResponses.html
+type Error struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+	RequestId  string `xml:"RequestId"`
+	// ContentRange is bytes */<size> on a 416, for the client to know the size of the object.
+	ContentRange string `xml:"-"`
+}
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/ErrorResponses.html#ErrorCodeList
+var errorCodes = map[string]error{
+	"NoSuchKey":                  service.ErrNotFound,
+	"NoSuchBucket":               service.ErrNotFound,
+	"NoSuchUpload":               service.ErrNotFound,
+	"AccessDenied":               service.ErrAccessDenied,
+	"AllAccessDisabled":          service.ErrAccessDenied,
+	"AccountProblem":             service.ErrAccessDenied,
+	"PreconditionFailed":         service.ErrPreconditionFailed,
+	"ConditionalRequestConflict": service.ErrPreconditionFailed,
+	"InvalidRange":               service.ErrRangeNotSatisfiable,
+	"SlowDown":                   service.ErrThrottled,
+	"ServiceUnavailable":         service.ErrThrottled,
+	"RequestLimitExceeded":       service.ErrThrottled,
+	"EntityTooLarge":             service.ErrTooLarge,
+	"MaxMessageLengthExceeded":   service.ErrTooLarge,
+	"EntityTooSmall":             service.ErrBadRequest,
+	"InvalidArgument":            service.ErrBadRequest,
+	"InvalidRequest":             service.ErrBadRequest,
+	"InvalidDigest":              service.ErrBadRequest,
+	"BadDigest":                  service.ErrBadRequest,
+	"KeyTooLongError":            service.ErrBadRequest,
+	"MetadataTooLarge":           service.ErrBadRequest,
+	"MalformedXML":               service.ErrBadRequest,
+	"InvalidPart":                service.ErrBadRequest,
+	"InvalidPartOrder":           service.ErrBadRequest,
+	"IncompleteBody":             service.ErrBadRequest,
+}
+
+// Credentials which AWS does not accept anymore, which are worth renewing before giving up.
+var credentialsErrorCodes = map[string]bool{
+	"ExpiredToken":         true,
+	"InvalidAccessKeyId":   true,
+	"InvalidToken":         true,
+	"TokenRefreshRequired": true,
+}
+
+// Responses to HEAD requests have no body, only the status tells what went wrong.
+var statusCodes = map[int]error{
+	http.StatusNotFound:                     service.ErrNotFound,
+	http.StatusForbidden:                    service.ErrAccessDenied,
+	http.StatusPreconditionFailed:           service.ErrPreconditionFailed,
+	http.StatusRequestedRangeNotSatisfiable: service.ErrRangeNotSatisfiable,
+	http.StatusServiceUnavailable:           service.ErrThrottled,
+	http.StatusRequestEntityTooLarge:        service.ErrTooLarge,
+	http.StatusBadRequest:                   service.ErrBadRequest,
+}
+
+// newError reads the error from an S3 response; the body is left for the caller to close.
+func newError(resp *http.Response) *Error {
+	s3Err := &Error{StatusCode: resp.StatusCode, ContentRange: resp.Header.Get("Content-Range")}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if err == nil && len(body) > 0 {
+		_ = xml.Unmarshal(body, s3Err)
+	}
+	return s3Err
+}
+
+func (s3Err *Error) Error() string {
+	if s3Err.Code == "" {
+		return fmt.Sprintf("S3 responded with %d %s", s3Err.StatusCode, http.StatusText(s3Err.StatusCode))
+	}
+	return fmt.Sprintf("S3 responded with %d %s: %s (request id %s)", s3Err.StatusCode, s3Err.Code, s3Err.Message, s3Err.RequestId)
+}
+
+// credentialsRejected tells whether the request failed because of stale credentials.
+func (s3Err *Error) credentialsRejected() bool {
+	return credentialsErrorCodes[s3Err.Code]
+}
+
+func (s3Err *Error) Unwrap() error {
+	if err, ok := errorCodes[s3Err.Code]; ok {
+		return err
+	}
+	return statusCodes[s3Err.StatusCode]
+}
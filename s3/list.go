@@ -0,0 +1,54 @@
+package s3
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/service"
+	"github.com/bluecatengineering/traefik-aws-plugin/signer"
+)
+
+// List returns a page of the objects under the configured prefix, which is left out of the keys.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_ListObjectsV2.html
+func (s3 *S3) List(options service.ListOptions) (*service.Listing, error) {
+	keyPrefix := strings.TrimPrefix(s3.prefix, "/")
+	if keyPrefix != "" {
+		keyPrefix += "/"
+	}
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", keyPrefix+options.Prefix)
+	query.Set("max-keys", strconv.Itoa(options.MaxKeys))
+	if options.Delimiter != "" {
+		query.Set("delimiter", options.Delimiter)
+	}
+	if options.ContinuationToken != "" {
+		query.Set("continuation-token", options.ContinuationToken)
+	}
+	if options.StartAfter != "" {
+		query.Set("start-after", keyPrefix+options.StartAfter)
+	}
+	resp, err := s3.send(http.MethodGet, s3.bucketUri+"/?"+signer.CanonicalQuery(query), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	listing := &service.Listing{}
+	if err = xml.NewDecoder(resp.Body).Decode(listing); err != nil {
+		return nil, fmt.Errorf("reading listing: %w", err)
+	}
+
+	listing.Prefix = strings.TrimPrefix(listing.Prefix, keyPrefix)
+	listing.StartAfter = strings.TrimPrefix(listing.StartAfter, keyPrefix)
+	for i := range listing.Contents {
+		listing.Contents[i].Key = strings.TrimPrefix(listing.Contents[i].Key, keyPrefix)
+	}
+	for i := range listing.CommonPrefixes {
+		listing.CommonPrefixes[i].Prefix = strings.TrimPrefix(listing.CommonPrefixes[i].Prefix, keyPrefix)
+	}
+	return listing, nil
+}
@@ -0,0 +1,302 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/log"
+	"github.com/bluecatengineering/traefik-aws-plugin/signer"
+)
+
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/qfacts.html
+const (
+	minPartSize = 5 << 20
+	maxParts    = 10000
+
+	defaultMultipartThreshold   = 16 << 20
+	defaultMultipartPartSize    = 8 << 20
+	defaultMultipartConcurrency = 4
+)
+
+// Multipart configures multipart uploads. Payloads larger than Threshold bytes are uploaded
+// in parts of PartSize bytes, Concurrency parts at a time. Zero values select the defaults.
+type Multipart struct {
+	Threshold   int64
+	PartSize    int64
+	Concurrency int
+}
+
+func (multipart Multipart) withDefaults() Multipart {
+	if multipart.Threshold <= 0 {
+		multipart.Threshold = defaultMultipartThreshold
+	}
+	if multipart.PartSize <= 0 {
+		multipart.PartSize = defaultMultipartPartSize
+	}
+	if multipart.PartSize < minPartSize {
+		log.Warn(fmt.Sprintf("multipart part size %d is below the S3 minimum, using %d", multipart.PartSize, minPartSize))
+		multipart.PartSize = minPartSize
+	}
+	if multipart.Concurrency <= 0 {
+		multipart.Concurrency = defaultMultipartConcurrency
+	}
+	return multipart
+}
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_CreateMultipartUpload.html#API_CreateMultipartUpload_ResponseSyntax
+type initiateMultipartUploadResult struct {
+	UploadId string `xml:"UploadId"`
+}
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_CompleteMultipartUpload.html#API_CompleteMultipartUpload_RequestSyntax
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completedPart struct {
+	PartNumber     int    `xml:"PartNumber"`
+	ETag           string `xml:"ETag"`
+	ChecksumCRC32  string `xml:"ChecksumCRC32,omitempty"`
+	ChecksumCRC32C string `xml:"ChecksumCRC32C,omitempty"`
+	ChecksumSHA1   string `xml:"ChecksumSHA1,omitempty"`
+	ChecksumSHA256 string `xml:"ChecksumSHA256,omitempty"`
+}
+
+// setChecksum records the checksum S3 computed for the part, which completing the upload requires.
+func (part *completedPart) setChecksum(algorithm string, checksum string) {
+	switch strings.ToLower(algorithm) {
+	case "crc32":
+		part.ChecksumCRC32 = checksum
+	case "crc32c":
+		part.ChecksumCRC32C = checksum
+	case "sha1":
+		part.ChecksumSHA1 = checksum
+	case "sha256":
+		part.ChecksumSHA256 = checksum
+	}
+}
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_CompleteMultipartUpload.html#API_CompleteMultipartUpload_ResponseSyntax
+type completeMultipartUploadResult struct {
+	XMLName   xml.Name
+	ETag      string `xml:"ETag"`
+	Code      string `xml:"Code"`
+	Message   string `xml:"Message"`
+	RequestId string `xml:"RequestId"`
+}
+
+// multipartUpload reads payload part by part and uploads up to multipart.Concurrency parts
+// in parallel. Parts signed as they are sent are streamed from payload instead, one after the
+// other. Any failure, including the client going away while the body is read, aborts the
+// upload so that S3 does not keep the uploaded parts around.
+// Conditional headers are evaluated by S3 when the upload is completed.
+func (s3 *S3) multipartUpload(name string, payload io.Reader, contentLength int64, header http.Header, rw http.ResponseWriter) ([]byte, error) {
+	uri := s3.objectUri(name)
+	createHeader := forwardHeader(header, "Content-Type")
+	if s3.signing.Checksum != "" {
+		// Parts are only accepted with a checksum when the upload announces it.
+		createHeader.Set("X-Amz-Checksum-Algorithm", strings.ToUpper(s3.signing.Checksum))
+	}
+	uploadId, err := s3.createMultipartUpload(uri, createHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		parts    []completedPart
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+	upload := func(partNumber int, part *spooledPayload) {
+		defer part.Close()
+		completed, err := s3.uploadPart(uri, uploadId, partNumber, part)
+		if err != nil {
+			fail(fmt.Errorf("uploading part %d failed: %w", partNumber, err))
+			return
+		}
+		mu.Lock()
+		parts = append(parts, completed)
+		mu.Unlock()
+	}
+	reader := &partReader{payload: payload, remaining: contentLength, partSize: s3.multipart.PartSize, streamed: s3.signing.signsWhileSending()}
+	slots := make(chan struct{}, s3.multipart.Concurrency)
+	for partNumber := 1; !failed(); partNumber++ {
+		if partNumber > maxParts {
+			fail(fmt.Errorf("payload exceeds %d parts of %d bytes", maxParts, s3.multipart.PartSize))
+			break
+		}
+		part, err := reader.next()
+		if err != nil {
+			fail(fmt.Errorf("reading part %d failed: %w", partNumber, err))
+			break
+		}
+		if part.size == 0 && partNumber > 1 {
+			part.Close()
+			break
+		}
+		if reader.streamed {
+			// The part is read from the payload as it is sent, before the next one can be.
+			upload(partNumber, part)
+		} else {
+			slots <- struct{}{}
+			wg.Add(1)
+			go func(partNumber int, part *spooledPayload) {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+				upload(partNumber, part)
+			}(partNumber, part)
+		}
+		if part.size < s3.multipart.PartSize {
+			break
+		}
+	}
+	wg.Wait()
+	if firstErr != nil {
+		log.Error(firstErr.Error())
+		s3.abortMultipartUpload(uri, uploadId)
+		return nil, firstErr
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	etag, err := s3.completeMultipartUpload(uri, uploadId, parts, forwardHeader(header, "If-Match", "If-None-Match"))
+	if err != nil {
+		s3.abortMultipartUpload(uri, uploadId)
+		return nil, err
+	}
+	rw.Header().Set("ETag", etag)
+	rw.Header().Add("Location", s3.prefix+"/"+name)
+	log.Debug(fmt.Sprintf("%q uploaded in %d parts", uri, len(parts)))
+	return nil, nil
+}
+
+// partReader cuts a payload into parts. Parts to be hashed are spooled, parts signed as they
+// are sent are read from the payload while they are: their length is what remains of the
+// payload, up to partSize, or when the length of the payload is unknown, what peeking a part
+// ahead finds, so that a single part is held in memory.
+type partReader struct {
+	payload io.Reader
+	// remaining is -1 when the length of the payload is unknown.
+	remaining int64
+	partSize  int64
+	streamed  bool
+	buffered  *bufio.Reader
+}
+
+func (reader *partReader) next() (*spooledPayload, error) {
+	if !reader.streamed {
+		return spool(io.LimitReader(reader.payload, reader.partSize), reader.partSize)
+	}
+	size := reader.partSize
+	if reader.remaining < 0 {
+		if reader.buffered == nil {
+			// Put may have peeked into the payload already, with a reader of the same size.
+			reader.buffered = bufio.NewReaderSize(reader.payload, int(reader.partSize))
+		}
+		peeked, err := reader.buffered.Peek(int(reader.partSize))
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		size = int64(len(peeked))
+		return &spooledPayload{Reader: io.LimitReader(reader.buffered, size), size: size}, nil
+	}
+	if reader.remaining < size {
+		size = reader.remaining
+	}
+	reader.remaining -= size
+	return &spooledPayload{Reader: io.LimitReader(reader.payload, size), size: size}, nil
+}
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_CreateMultipartUpload.html
+func (s3 *S3) createMultipartUpload(uri string, header http.Header) (string, error) {
+	resp, err := s3.send(http.MethodPost, uri+"?uploads", nil, header)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	result := &initiateMultipartUploadResult{}
+	if err = xml.NewDecoder(resp.Body).Decode(result); err != nil {
+		return "", fmt.Errorf("decoding CreateMultipartUpload response failed: %w", err)
+	}
+	return result.UploadId, nil
+}
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_UploadPart.html
+func (s3 *S3) uploadPart(uri string, uploadId string, partNumber int, part *spooledPayload) (completedPart, error) {
+	query := url.Values{}
+	query.Set("partNumber", strconv.Itoa(partNumber))
+	query.Set("uploadId", uploadId)
+	resp, err := s3.send(http.MethodPut, uri+"?"+signer.CanonicalQuery(query), part, nil)
+	if err != nil {
+		return completedPart{}, err
+	}
+	resp.Body.Close()
+	completed := completedPart{PartNumber: partNumber, ETag: resp.Header.Get("ETag")}
+	if s3.signing.Checksum != "" {
+		completed.setChecksum(s3.signing.Checksum, resp.Header.Get(signer.ChecksumHeader(s3.signing.Checksum)))
+	}
+	return completed, nil
+}
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_CompleteMultipartUpload.html
+func (s3 *S3) completeMultipartUpload(uri string, uploadId string, parts []completedPart, header http.Header) (string, error) {
+	body, err := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if err != nil {
+		return "", err
+	}
+	payload, err := spool(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return "", err
+	}
+	defer payload.Close()
+	header.Set("Content-Type", "application/xml")
+	resp, err := s3.send(http.MethodPost, uri+"?uploadId="+signer.URIEncode(uploadId, true), payload, header)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	// S3 may report a failure with a 200 status, the body tells which one it is.
+	result := &completeMultipartUploadResult{}
+	if err = xml.NewDecoder(resp.Body).Decode(result); err != nil {
+		return "", fmt.Errorf("decoding CompleteMultipartUpload response failed: %w", err)
+	}
+	if result.XMLName.Local == "Error" {
+		s3Err := &Error{StatusCode: resp.StatusCode, Code: result.Code, Message: result.Message, RequestId: result.RequestId}
+		return "", fmt.Errorf("CompleteMultipartUpload %q: %w", uri, s3Err)
+	}
+	return result.ETag, nil
+}
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_AbortMultipartUpload.html
+func (s3 *S3) abortMultipartUpload(uri string, uploadId string) {
+	resp, err := s3.send(http.MethodDelete, uri+"?uploadId="+signer.URIEncode(uploadId, true), nil, nil)
+	if err != nil {
+		log.Error(fmt.Sprintf("aborting multipart upload %q failed: %s", uploadId, err.Error()))
+		return
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,24 @@
+package s3
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/signer"
+)
+
+// Presign returns a URL giving access to the object with httpMethod until expires elapses,
+// or until the credentials it is signed with expire, whichever comes first.
+func (s3 *S3) Presign(httpMethod string, name string, expires time.Duration) (string, error) {
+	creds, err := s3.creds.Get(time.Duration(s3.timeoutSeconds) * time.Second)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(httpMethod, s3.objectUri(name), nil)
+	if err != nil {
+		return "", err
+	}
+	crTemplate := s3.crTemplate
+	crTemplate.Creds = creds
+	return signer.Presign(req, expires, crTemplate).String(), nil
+}
@@ -0,0 +1,189 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/service"
+)
+
+// byteRange is a range of an object resolved against its size, end included.
+type byteRange struct {
+	start, end int64
+}
+
+func (r byteRange) header() string {
+	return fmt.Sprintf("bytes=%d-%d", r.start, r.end)
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, size)
+}
+
+// maxRanges bounds the GetObject requests a single multi-range request fans out into.
+const maxRanges = 16
+
+var (
+	errInvalidRange = errors.New("invalid range")
+	errNoOverlap    = errors.New("no satisfiable range")
+)
+
+// parseRange resolves a Range header against an object of the given size, dropping the
+// unsatisfiable ranges. It fails with errInvalidRange when the header is malformed, and with
+// errNoOverlap when no range is satisfiable.
+// https://www.rfc-editor.org/rfc/rfc9110#name-range
+func parseRange(header string, size int64) ([]byteRange, error) {
+	const unit = "bytes="
+	if !strings.HasPrefix(header, unit) {
+		return nil, fmt.Errorf("%w %q", errInvalidRange, header)
+	}
+	var ranges []byteRange
+	for _, spec := range strings.Split(header[len(unit):], ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, fmt.Errorf("%w %q", errInvalidRange, spec)
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+		if first == "" {
+			// Suffix range, the last n bytes.
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%w %q", errInvalidRange, spec)
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			ranges = append(ranges, byteRange{start: size - n, end: size - 1})
+			continue
+		}
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("%w %q", errInvalidRange, spec)
+		}
+		end := size - 1
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil || end < start {
+				return nil, fmt.Errorf("%w %q", errInvalidRange, spec)
+			}
+			if end >= size {
+				end = size - 1
+			}
+		}
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, byteRange{start: start, end: end})
+	}
+	if len(ranges) == 0 {
+		return nil, errNoOverlap
+	}
+	return ranges, nil
+}
+
+// worthServing tells whether ranges are better served as such than as the whole object, the
+// way http.ServeContent decides: there are not too many of them, they do not overlap and add up
+// to less than the object.
+func worthServing(ranges []byteRange, size int64) bool {
+	if len(ranges) > maxRanges {
+		return false
+	}
+	sorted := append([]byteRange(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].start < sorted[j].start })
+	var total int64
+	for i, r := range sorted {
+		if i > 0 && r.start <= sorted[i-1].end {
+			return false
+		}
+		total += r.end - r.start + 1
+	}
+	return total <= size
+}
+
+// getRanges answers a multi-range request with a multipart/byteranges body, fetching each
+// range with its own GetObject request. The preconditions are evaluated once, by HeadObject.
+// An invalid Range header is ignored, as S3 does for a single range, and ranges not worth
+// serving are answered with the whole object.
+func (s3 *S3) getRanges(name string, reqHeader http.Header, rw http.ResponseWriter) error {
+	uri := s3.objectUri(name)
+	head, err := s3.send(http.MethodHead, uri, nil, forwardHeader(reqHeader, conditionalHeaders...))
+	if err != nil {
+		return err
+	}
+	head.Body.Close()
+	if head.StatusCode == http.StatusNotModified {
+		copyHeader(rw.Header(), head.Header)
+		rw.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	size := head.ContentLength
+	ranges, err := parseRange(reqHeader.Get("Range"), size)
+	switch {
+	case errors.Is(err, errNoOverlap):
+		rw.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		return fmt.Errorf("%w: %q: %s", service.ErrRangeNotSatisfiable, uri, err.Error())
+	case err != nil || !worthServing(ranges, size):
+		ranges = nil
+	}
+
+	// Every range comes from the same version of the object, any change fails the If-Match.
+	header := http.Header{}
+	if etag := head.Header.Get("ETag"); etag != "" {
+		header.Set("If-Match", etag)
+	}
+	if len(ranges) <= 1 {
+		if len(ranges) == 1 {
+			header.Set("Range", ranges[0].header())
+		}
+		resp, err := s3.request(http.MethodGet, name, nil, header, rw)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		rw.WriteHeader(resp.StatusCode)
+		_, err = io.Copy(rw, resp.Body)
+		return err
+	}
+
+	parts := multipart.NewWriter(rw)
+	for _, k := range []string{"ETag", "Last-Modified", "Accept-Ranges"} {
+		if v := head.Header.Get(k); v != "" {
+			rw.Header().Set(k, v)
+		}
+	}
+	rw.Header().Set("Content-Type", "multipart/byteranges; boundary="+parts.Boundary())
+	rw.WriteHeader(http.StatusPartialContent)
+	for _, r := range ranges {
+		header.Set("Range", r.header())
+		resp, err := s3.send(http.MethodGet, uri, nil, header)
+		if err != nil {
+			return err
+		}
+		part, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {head.Header.Get("Content-Type")},
+			"Content-Range": {r.contentRange(size)},
+		})
+		if err == nil {
+			_, err = io.Copy(part, resp.Body)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return parts.Close()
+}
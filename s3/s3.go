@@ -1,91 +1,399 @@
 package s3
 
 import (
-	"bytes"
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/bluecatengineering/traefik-aws-plugin/ecs"
+	"github.com/bluecatengineering/traefik-aws-plugin/credentials"
 	"github.com/bluecatengineering/traefik-aws-plugin/log"
+	"github.com/bluecatengineering/traefik-aws-plugin/service"
 	"github.com/bluecatengineering/traefik-aws-plugin/signer"
 	"github.com/google/uuid"
 )
 
+// Signing configures how requests are signed. SignedHeaders are signed on top of those SigV4
+// requires. With Streaming, payloads are signed chunk by chunk as they are sent rather than
+// hashed beforehand: they are never spooled to disk, and held in memory a part at most when
+// their length is unknown. Unsigned payloads are sent the same way but left out of the signature, relying on
+// TLS for their integrity, along with a trailing checksum computed on the way if Checksum names
+// an algorithm: crc32, crc32c, sha1 or sha256. V4a signs with SigV4a, which multi-region access
+// points require, the signature being valid in each region of RegionSet, all of them when empty.
+type Signing struct {
+	SignedHeaders []string
+	V4a           bool
+	RegionSet     []string
+	Streaming     bool
+	Unsigned      bool
+	Checksum      string
+}
+
+func (signing Signing) validate() error {
+	if signing.Streaming && signing.Unsigned {
+		return fmt.Errorf("payloads are either signed as they are streamed or unsigned, not both")
+	}
+	if signing.Streaming && signing.V4a {
+		return fmt.Errorf("streaming signatures are not supported with SigV4a")
+	}
+	if signing.Checksum == "" {
+		return nil
+	}
+	if !signing.Unsigned {
+		return fmt.Errorf("a checksum requires unsigned payloads")
+	}
+	_, err := signer.NewChecksum(signing.Checksum)
+	return err
+}
+
+// signsWhileSending tells whether payloads are sent without being hashed beforehand.
+func (signing Signing) signsWhileSending() bool {
+	return signing.Streaming || signing.Unsigned
+}
+
 type S3 struct {
 	client         *http.Client
-	crTemplate     *signer.CanonRequest
+	signing        Signing
+	crTemplate     signer.CanonRequest
+	creds          *credentials.Store
 	bucketUri      string
 	prefix         string
 	timeoutSeconds int
+	multipart      Multipart
 }
 
-func New(bucket, prefix, region string, timeoutSeconds int, creds *ecs.Credentials) *S3 {
-	crTemplate := &signer.CanonRequest{
-		Creds:   creds,
-		Region:  region,
-		Service: "s3",
+func New(bucket, prefix, region string, timeoutSeconds int, endpoint Endpoint, multipart Multipart, signing Signing, creds *credentials.Store) (*S3, error) {
+	bucketUri, err := endpoint.bucketUri(bucket, region)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(bucket, "arn:") {
+		// Parsed already, to resolve the bucket URI.
+		accessPoint, _ := parseAccessPoint(bucket)
+		if accessPoint.multiRegion {
+			signing.V4a = true
+		} else {
+			region = accessPoint.region
+		}
+	}
+	if !signing.V4a {
+		signing.RegionSet = nil
+	} else if len(signing.RegionSet) == 0 {
+		signing.RegionSet = []string{"*"}
+	}
+	if err = signing.validate(); err != nil {
+		return nil, err
+	}
+	crTemplate := signer.CanonRequest{
+		Region:        region,
+		Service:       "s3",
+		SignedHeaders: signing.SignedHeaders,
+		RegionSet:     signing.RegionSet,
 	}
 	return &S3{
 		client:         &http.Client{},
+		signing:        signing,
 		crTemplate:     crTemplate,
-		bucketUri:      fmt.Sprintf("https://%s.s3.amazonaws.com", bucket),
+		creds:          creds,
+		bucketUri:      bucketUri,
 		prefix:         prefix,
 		timeoutSeconds: timeoutSeconds,
+		multipart:      multipart.withDefaults(),
+	}, nil
+}
+
+// objectUri encodes the key of the object the way it is signed, for S3 to find the same path.
+func (s3 *S3) objectUri(name string) string {
+	return s3.bucketUri + signer.URIEncode(s3.prefix+"/"+name, false)
+}
+
+// send signs and sends a request to S3. The timeout covers the request from the end of its
+// payload until S3 responds, so that a slow client upload is not cut short; the response body
+// is then streamed for as long as it takes and the context is released
+// when it is closed. A request rejected because of stale credentials is retried once with
+// renewed ones.
+func (s3 *S3) send(httpMethod string, uri string, payload *spooledPayload, header http.Header) (*http.Response, error) {
+	timeout := time.Duration(s3.timeoutSeconds) * time.Second
+	// Until the first credentials are retrieved, wait for them rather than sign with nothing.
+	creds, err := s3.creds.Get(timeout)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
 	}
+	resp, err := s3.sendSigned(creds, timeout, httpMethod, uri, payload, header)
+	var s3Err *Error
+	if !errors.As(err, &s3Err) || !s3Err.credentialsRejected() {
+		return resp, err
+	}
+	log.Debug(fmt.Sprintf("%s %q: renewing credentials: %s", httpMethod, uri, s3Err.Code))
+	creds, renewErr := s3.creds.Renew(creds, timeout)
+	if renewErr != nil {
+		log.Error(renewErr.Error())
+		return nil, err
+	}
+	if payload != nil {
+		if renewErr = payload.rewind(); renewErr != nil {
+			log.Error(renewErr.Error())
+			return nil, err
+		}
+	}
+	return s3.sendSigned(creds, timeout, httpMethod, uri, payload, header)
 }
 
-func (s3 *S3) request(httpMethod string, name string, payload []byte, contentType string, rw http.ResponseWriter) ([]byte, error) {
-	uri := s3.bucketUri + s3.prefix + "/" + name
+func (s3 *S3) sendSigned(creds *credentials.Credentials, timeout time.Duration, httpMethod string, uri string, payload *spooledPayload, header http.Header) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	timer := &responseTimer{timeout: timeout, cancel: cancel}
 	var payloadReader io.Reader = nil
+	payloadHash := signer.EmptyPayloadHash
 	if payload != nil {
-		payloadReader = bytes.NewReader(payload)
+		payloadHash = payload.hash
+	}
+	if payload != nil && payload.size != 0 {
+		payloadReader = &startOnEOF{Reader: payload, start: timer.start}
+	} else {
+		timer.start()
 	}
 	req, err := http.NewRequest(httpMethod, uri, payloadReader)
 	if err != nil {
+		timer.stop()
+		cancel()
 		log.Error(err.Error())
 		return nil, err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s3.timeoutSeconds)*time.Second)
-	if cancel != nil {
-		defer cancel()
+	if payload != nil {
+		req.ContentLength = payload.size
+		if payload.size == 0 {
+			req.Body = http.NoBody
+		}
 	}
-	if contentType != "" {
-		req.Header.Set("Content-Type", contentType)
+	for k, vv := range header {
+		req.Header[k] = vv
 	}
 	req.Header.Set("Host", req.URL.Host)
-	cr := signer.CreateCanonRequest(req, payload, *s3.crTemplate)
+	crTemplate := s3.crTemplate
+	crTemplate.Creds = creds
+	var cr *signer.CanonRequest
+	switch {
+	case payload == nil || payload.hash != "":
+		cr = signer.CreateCanonRequest(req, payloadHash, crTemplate)
+	case s3.signing.Unsigned && s3.signing.Checksum != "":
+		cr, err = signer.SignUnsignedTrailer(req, payload.size, signer.DefaultChunkSize, s3.signing.Checksum, crTemplate)
+	case s3.signing.Unsigned:
+		cr = signer.CreateCanonRequest(req, signer.UnsignedPayload, crTemplate)
+	default:
+		cr = signer.SignStreaming(req, payload.size, signer.DefaultChunkSize, crTemplate)
+	}
+	if err != nil {
+		timer.stop()
+		cancel()
+		log.Error(err.Error())
+		return nil, err
+	}
 	req.Header.Set("Authorization", cr.AuthHeader())
 	resp, err := s3.client.Do(req.WithContext(ctx))
+	timer.stop()
 	if err != nil {
-		log.Error(fmt.Sprintf("%s %q failed, status: %q, error: %s", httpMethod, uri, resp.Status, err.Error()))
+		cancel()
+		log.Error(fmt.Sprintf("%s %q failed, error: %s", httpMethod, uri, err.Error()))
 		return nil, err
 	}
-	if resp.StatusCode > 299 {
-		return nil, fmt.Errorf(cr.RequestString())
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	// A 304 is the answer to a conditional request, left for the caller to forward.
+	if resp.StatusCode > 299 && resp.StatusCode != http.StatusNotModified {
+		s3Err := newError(resp)
+		resp.Body.Close()
+		if s3Err.Code == "SignatureDoesNotMatch" {
+			log.Debug(fmt.Sprintf("canonical request:\n%s", cr.RequestString()))
+		}
+		return nil, fmt.Errorf("%s %q: %w", httpMethod, uri, s3Err)
 	}
-	response, err := io.ReadAll(resp.Body)
+	return resp, nil
+}
+
+func (s3 *S3) request(httpMethod string, name string, payload *spooledPayload, header http.Header, rw http.ResponseWriter) (*http.Response, error) {
+	resp, err := s3.send(httpMethod, s3.objectUri(name), payload, header)
 	if err != nil {
-		log.Error(fmt.Sprintf("Reading S3 response body failed: %q", err.Error()))
+		return nil, err
 	}
 	resp.Header.Add("Location", s3.prefix+"/"+name)
 	copyHeader(rw.Header(), resp.Header)
 
+	return resp, nil
+}
+
+// Put uploads payload in a single PutObject request, or as a multipart upload when it is
+// larger than the configured threshold. If-Match and If-None-Match are forwarded for S3 to
+// evaluate, If-None-Match: * prevents overwriting an existing object.
+func (s3 *S3) Put(name string, payload io.Reader, contentLength int64, header http.Header, rw http.ResponseWriter) ([]byte, error) {
+	header = forwardHeader(header, "Content-Type", "If-Match", "If-None-Match")
+	if s3.signing.signsWhileSending() && contentLength < 0 {
+		// Peeking a part ahead tells whether the payload ends within it, its length then known.
+		buffered := bufio.NewReaderSize(payload, int(s3.multipart.PartSize))
+		peeked, err := buffered.Peek(int(s3.multipart.PartSize))
+		switch {
+		case err == nil:
+			return s3.multipartUpload(name, buffered, -1, header, rw)
+		case err != io.EOF:
+			log.Error(fmt.Sprintf("Reading body failed: %s", err.Error()))
+			return nil, err
+		}
+		payload, contentLength = buffered, int64(len(peeked))
+	}
+	if contentLength > s3.multipart.Threshold {
+		return s3.multipartUpload(name, payload, contentLength, header, rw)
+	}
+	if s3.signing.signsWhileSending() {
+		// Signed as it is sent, the payload goes straight to S3.
+		return s3.putObject(name, &spooledPayload{Reader: payload, size: contentLength}, header, rw)
+	}
+	spooled, err := spool(io.LimitReader(payload, s3.multipart.Threshold+1), contentLength)
+	if err != nil {
+		log.Error(fmt.Sprintf("Reading body failed: %s", err.Error()))
+		return nil, err
+	}
+	defer spooled.Close()
+	if spooled.size > s3.multipart.Threshold {
+		// Unknown length: what has been read so far goes first, followed by the rest of the body.
+		return s3.multipartUpload(name, io.MultiReader(spooled, payload), -1, header, rw)
+	}
+	return s3.putObject(name, spooled, header, rw)
+}
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_PutObject.html
+func (s3 *S3) putObject(name string, payload *spooledPayload, header http.Header, rw http.ResponseWriter) ([]byte, error) {
+	resp, err := s3.request(http.MethodPut, name, payload, header, rw)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	response, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Error(fmt.Sprintf("Reading S3 response body failed: %q", err.Error()))
+	}
 	return response, nil
 }
 
-func (s3 *S3) Put(name string, payload []byte, contentType string, rw http.ResponseWriter) ([]byte, error) {
-	return s3.request(http.MethodPut, name, payload, contentType, rw)
+func (s3 *S3) Post(path string, payload io.Reader, contentLength int64, header http.Header, rw http.ResponseWriter) ([]byte, error) {
+	return s3.Put(path+"/"+uuid.NewString(), payload, contentLength, header, rw)
+}
+
+// Get streams the object, or the byte ranges requested with a Range header.
+// S3 serves a single range per request, multiple ranges are fetched one by one.
+// Conditional headers are forwarded, S3 answers with 304 or 412 when they do not hold.
+func (s3 *S3) Get(name string, req *http.Request, rw http.ResponseWriter) error {
+	if strings.Contains(req.Header.Get("Range"), ",") {
+		return s3.getRanges(name, req.Header, rw)
+	}
+	header := forwardHeader(req.Header, append([]string{"Range"}, conditionalHeaders...)...)
+	resp, err := s3.request(http.MethodGet, name, nil, header, rw)
+	var s3Err *Error
+	if errors.As(err, &s3Err) && s3Err.ContentRange != "" {
+		rw.Header().Set("Content-Range", s3Err.ContentRange)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	rw.WriteHeader(resp.StatusCode)
+	_, err = io.Copy(rw, resp.Body)
+	return err
+}
+
+// Delete removes the object. Like S3, it succeeds whether or not the object exists.
+func (s3 *S3) Delete(name string, rw http.ResponseWriter) error {
+	resp, err := s3.send(http.MethodDelete, s3.objectUri(name), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	copyHeader(rw.Header(), resp.Header)
+	return nil
+}
+
+// Head sets the object headers returned by HeadObject, such as Content-Length, ETag,
+// Content-Type and Last-Modified.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_HeadObject.html
+func (s3 *S3) Head(name string, req *http.Request, rw http.ResponseWriter) error {
+	resp, err := s3.send(http.MethodHead, s3.objectUri(name), nil, forwardHeader(req.Header, conditionalHeaders...))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	copyHeader(rw.Header(), resp.Header)
+	if resp.StatusCode == http.StatusNotModified {
+		return fmt.Errorf("%w: %q", service.ErrNotModified, name)
+	}
+	return nil
 }
 
-func (s3 *S3) Post(path string, payload []byte, contentType string, rw http.ResponseWriter) ([]byte, error) {
-	return s3.Put(path+"/"+uuid.NewString(), payload, contentType, rw)
+// responseTimer cancels a request which S3 has not answered within timeout of its start.
+type responseTimer struct {
+	mu      sync.Mutex
+	timer   *time.Timer
+	stopped bool
+	timeout time.Duration
+	cancel  context.CancelFunc
 }
 
-func (s3 *S3) Get(name string, rw http.ResponseWriter) ([]byte, error) {
-	return s3.request(http.MethodGet, name, nil, "", rw)
+func (rt *responseTimer) start() {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if !rt.stopped && rt.timer == nil {
+		rt.timer = time.AfterFunc(rt.timeout, rt.cancel)
+	}
+}
+
+func (rt *responseTimer) stop() {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.stopped = true
+	if rt.timer != nil {
+		rt.timer.Stop()
+	}
+}
+
+// startOnEOF calls start once the payload has been read entirely, or failed.
+type startOnEOF struct {
+	io.Reader
+	start func()
+}
+
+func (body *startOnEOF) Read(p []byte) (int, error) {
+	n, err := body.Reader.Read(p)
+	if err != nil {
+		body.start()
+	}
+	return n, err
+}
+
+// cancelOnClose releases the request context once the response body has been consumed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (body *cancelOnClose) Close() error {
+	defer body.cancel()
+	return body.ReadCloser.Close()
+}
+
+// conditionalHeaders are the preconditions of reads, which S3 evaluates.
+var conditionalHeaders = []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"}
+
+// forwardHeader picks the given headers of an incoming request to forward them to S3.
+func forwardHeader(src http.Header, keys ...string) http.Header {
+	header := http.Header{}
+	for _, k := range keys {
+		if v := src.Get(k); v != "" {
+			header.Set(k, v)
+		}
+	}
+	return header
 }
 
 func copyHeader(dst, src http.Header) {
@@ -0,0 +1,685 @@
+package s3
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/credentials"
+	"github.com/bluecatengineering/traefik-aws-plugin/service"
+)
+
+// fakeS3 is a minimal in-memory S3 bucket speaking just enough of the REST API for the tests.
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	uploads  map[string]map[int][]byte
+	aborted  int
+	failPart int
+	// expired requests are rejected as signed with an expired token.
+	expired int
+	// chunked counts the requests with an aws-chunked body.
+	chunked int
+	// payloadHashes are the X-Amz-Content-Sha256 of the requests with a body.
+	payloadHashes []string
+	completeBody  string
+}
+
+// decodeChunked strips the chunk headers and trailers of an aws-chunked body.
+func decodeChunked(body []byte) []byte {
+	var decoded []byte
+	for {
+		header, rest, _ := bytes.Cut(body, []byte("\r\n"))
+		size, _ := strconv.ParseInt(string(bytes.SplitN(header, []byte(";"), 2)[0]), 16, 64)
+		if size == 0 {
+			return decoded
+		}
+		decoded = append(decoded, rest[:size]...)
+		body = rest[size+2:]
+	}
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *S3) {
+	fake := &fakeS3{objects: map[string][]byte{}, uploads: map[string]map[int][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	endpoint := Endpoint{URL: server.URL, PathStyle: true}
+	s3, err := New("bucket", "/prefix", "us-east-1", 5, endpoint, Multipart{}, Signing{}, credentials.NewStore(&credentials.Static{AccessKeyId: "KEY", AccessSecretKey: "SECRET"}, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, s3
+}
+
+func (fake *fakeS3) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	key := req.URL.Path
+	query := req.URL.Query()
+	body, _ := io.ReadAll(req.Body)
+	if req.Header.Get("Content-Encoding") == "aws-chunked" {
+		fake.chunked++
+		body = decodeChunked(body)
+	}
+	if req.ContentLength > 0 {
+		fake.payloadHashes = append(fake.payloadHashes, req.Header.Get("X-Amz-Content-Sha256"))
+	}
+	if trailer := req.Header.Get("X-Amz-Trailer"); trailer != "" {
+		rw.Header().Set(trailer, "checksum-"+query.Get("partNumber"))
+	}
+	if fake.expired > 0 {
+		fake.expired--
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(rw, "<Error><Code>ExpiredToken</Code></Error>")
+		return
+	}
+	switch {
+	case req.Method == http.MethodGet && query.Get("list-type") == "2":
+		fmt.Fprintf(rw, "<ListBucketResult><Prefix>%s</Prefix><MaxKeys>%s</MaxKeys>", query.Get("prefix"), query.Get("max-keys"))
+		for objectKey, object := range fake.objects {
+			if strings.HasPrefix(objectKey, "/bucket/"+query.Get("prefix")) {
+				fmt.Fprintf(rw, "<Contents><Key>%s</Key><Size>%d</Size><ETag>\"etag\"</ETag><LastModified>2024-01-01T00:00:00.000Z</LastModified></Contents>", objectKey[len("/bucket/"):], len(object))
+			}
+		}
+		fmt.Fprint(rw, "<CommonPrefixes><Prefix>prefix/dir/</Prefix></CommonPrefixes></ListBucketResult>")
+	case req.Method == http.MethodPost && query.Has("uploads"):
+		uploadId := fmt.Sprintf("upload-%d", len(fake.uploads)+1)
+		fake.uploads[uploadId] = map[int][]byte{}
+		fmt.Fprintf(rw, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", uploadId)
+	case req.Method == http.MethodPut && query.Has("partNumber"):
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		if partNumber == fake.failPart {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fake.uploads[query.Get("uploadId")][partNumber] = body
+		rw.Header().Set("ETag", fmt.Sprintf("\"etag-%d\"", partNumber))
+	case req.Method == http.MethodPost && query.Has("uploadId"):
+		complete := &completeMultipartUpload{}
+		_ = xml.Unmarshal(body, complete)
+		fake.completeBody = string(body)
+		parts := fake.uploads[query.Get("uploadId")]
+		var object []byte
+		for _, part := range complete.Parts {
+			object = append(object, parts[part.PartNumber]...)
+		}
+		fake.objects[key] = object
+		delete(fake.uploads, query.Get("uploadId"))
+		fmt.Fprint(rw, "<CompleteMultipartUploadResult><ETag>\"multipart\"</ETag></CompleteMultipartUploadResult>")
+	case req.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(fake.uploads, query.Get("uploadId"))
+		fake.aborted++
+		rw.WriteHeader(http.StatusNoContent)
+	case req.Method == http.MethodPut:
+		if _, ok := fake.objects[key]; ok && req.Header.Get("If-None-Match") == "*" {
+			rw.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		fake.objects[key] = body
+	case req.Method == http.MethodDelete:
+		delete(fake.objects, key)
+		rw.WriteHeader(http.StatusNoContent)
+	case req.Method == http.MethodGet || req.Method == http.MethodHead:
+		object, ok := fake.objects[key]
+		if !ok {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rw.Header().Set("ETag", "\"etag\"")
+		rw.Header().Set("Content-Type", "text/plain")
+		http.ServeContent(rw, req, key, time.Time{}, bytes.NewReader(object))
+	default:
+		rw.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestPutMultipart(t *testing.T) {
+	testCases := []struct {
+		name          string
+		size          int
+		contentLength int64
+		multipart     bool
+	}{
+		{name: "single put", size: 1 << 20, contentLength: 1 << 20},
+		{name: "known length", size: 20 << 20, contentLength: 20 << 20, multipart: true},
+		{name: "unknown length", size: 20 << 20, contentLength: -1, multipart: true},
+		{name: "exact parts", size: 24 << 20, contentLength: -1, multipart: true},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, s3 := newFakeS3(t)
+			payload := strings.Repeat("x", tt.size)
+			rw := httptest.NewRecorder()
+			if _, err := s3.Put("object", strings.NewReader(payload), tt.contentLength, http.Header{"Content-Type": {"text/plain"}}, rw); err != nil {
+				t.Fatal(err)
+			}
+			if got := string(fake.objects["/bucket/prefix/object"]); got != payload {
+				t.Errorf("expected %d bytes stored, found %d", len(payload), len(got))
+			}
+			if tt.multipart && rw.Header().Get("ETag") != "\"multipart\"" {
+				t.Errorf("expected the multipart ETag, found %q", rw.Header().Get("ETag"))
+			}
+			if len(fake.uploads) != 0 {
+				t.Errorf("expected no pending upload, found %d", len(fake.uploads))
+			}
+		})
+	}
+}
+
+func TestPutStreaming(t *testing.T) {
+	testCases := []struct {
+		name          string
+		size          int
+		contentLength int64
+		requests      int
+	}{
+		{name: "known length", size: 100 << 10, contentLength: 100 << 10, requests: 1},
+		{name: "unknown length", size: 100 << 10, contentLength: -1, requests: 1},
+		{name: "empty", size: 0, contentLength: 0, requests: 1},
+		{name: "multipart", size: 20 << 20, contentLength: 20 << 20, requests: 3},
+		{name: "unknown length multipart", size: 20 << 20, contentLength: -1, requests: 3},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, s3 := newFakeS3(t)
+			s3.signing.Streaming = true
+			payload := bytes.Repeat([]byte("0123456789"), tt.size/10)
+			if _, err := s3.Put("object", bytes.NewReader(payload), tt.contentLength, http.Header{}, httptest.NewRecorder()); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(fake.objects["/bucket/prefix/object"], payload) {
+				t.Errorf("payload mismatch, found %d bytes", len(fake.objects["/bucket/prefix/object"]))
+			}
+			if fake.chunked != tt.requests {
+				t.Errorf("expected %d aws-chunked requests, found %d", tt.requests, fake.chunked)
+			}
+		})
+	}
+}
+
+func TestPutUnsigned(t *testing.T) {
+	testCases := []struct {
+		name          string
+		checksum      string
+		size          int
+		contentLength int64
+		payloadHash   string
+	}{
+		{name: "unsigned", size: 100 << 10, contentLength: 100 << 10, payloadHash: "UNSIGNED-PAYLOAD"},
+		{name: "unsigned unknown length", size: 100 << 10, contentLength: -1, payloadHash: "UNSIGNED-PAYLOAD"},
+		{name: "checksum", checksum: "crc32c", size: 100 << 10, contentLength: 100 << 10, payloadHash: "STREAMING-UNSIGNED-PAYLOAD-TRAILER"},
+		{name: "checksum multipart", checksum: "sha256", size: 20 << 20, contentLength: -1, payloadHash: "STREAMING-UNSIGNED-PAYLOAD-TRAILER"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, s3 := newFakeS3(t)
+			s3.signing = Signing{Unsigned: true, Checksum: tt.checksum}
+			payload := bytes.Repeat([]byte("0123456789"), tt.size/10)
+			if _, err := s3.Put("object", bytes.NewReader(payload), tt.contentLength, http.Header{}, httptest.NewRecorder()); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(fake.objects["/bucket/prefix/object"], payload) {
+				t.Errorf("payload mismatch, found %d bytes", len(fake.objects["/bucket/prefix/object"]))
+			}
+			if len(fake.payloadHashes) == 0 || fake.payloadHashes[0] != tt.payloadHash {
+				t.Errorf("expected the payload hash %s, found %v", tt.payloadHash, fake.payloadHashes)
+			}
+			if tt.size > 16<<20 && !strings.Contains(fake.completeBody, "<ChecksumSHA256>checksum-1</ChecksumSHA256>") {
+				t.Errorf("expected the checksums of the parts, found %s", fake.completeBody)
+			}
+		})
+	}
+
+	if _, err := New("bucket", "", "us-east-1", 5, Endpoint{}, Multipart{}, Signing{Checksum: "crc32c"}, nil); err == nil {
+		t.Error("expected a checksum without unsigned payloads to be refused")
+	}
+	if _, err := New("bucket", "", "us-east-1", 5, Endpoint{}, Multipart{}, Signing{Unsigned: true, Checksum: "md5"}, nil); err == nil {
+		t.Error("expected an unsupported checksum to be refused")
+	}
+}
+
+// slowReader hands out its payload a chunk at a time, pausing before each.
+type slowReader struct {
+	payload []byte
+	chunk   int
+	pause   time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if len(r.payload) == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(r.pause)
+	n := copy(p[:r.chunk], r.payload)
+	r.payload = r.payload[n:]
+	return n, nil
+}
+
+func TestPutSlowClient(t *testing.T) {
+	for _, signing := range []Signing{{Streaming: true}, {Unsigned: true}} {
+		fake, s3 := newFakeS3(t)
+		s3.signing = signing
+		s3.timeoutSeconds = 1
+		payload := bytes.Repeat([]byte("0123456789"), 1000)
+		// The upload takes 1.5 seconds, more than the timeout, which only runs once it is over.
+		reader := &slowReader{payload: payload, chunk: len(payload) / 5, pause: 300 * time.Millisecond}
+		if _, err := s3.Put("object", reader, int64(len(payload)), http.Header{}, httptest.NewRecorder()); err != nil {
+			t.Fatalf("%+v: %v", signing, err)
+		}
+		if !bytes.Equal(fake.objects["/bucket/prefix/object"], payload) {
+			t.Errorf("%+v: payload mismatch, found %d bytes", signing, len(fake.objects["/bucket/prefix/object"]))
+		}
+	}
+}
+
+// zeroReader hands out size zero bytes, of unknown length to the reader.
+type zeroReader struct {
+	size int64
+}
+
+func (r *zeroReader) Read(p []byte) (int, error) {
+	if r.size == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.size {
+		p = p[:r.size]
+	}
+	for i := range p {
+		p[i] = 0
+	}
+	r.size -= int64(len(p))
+	return len(p), nil
+}
+
+func TestPutStreamingMemory(t *testing.T) {
+	var parts int
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.Copy(io.Discard, req.Body)
+		query := req.URL.Query()
+		switch {
+		case query.Has("uploads"):
+			fmt.Fprint(rw, "<InitiateMultipartUploadResult><UploadId>upload</UploadId></InitiateMultipartUploadResult>")
+		case query.Has("partNumber"):
+			parts++
+			rw.Header().Set("ETag", "\"etag\"")
+		default:
+			fmt.Fprint(rw, "<CompleteMultipartUploadResult><ETag>\"multipart\"</ETag></CompleteMultipartUploadResult>")
+		}
+	}))
+	t.Cleanup(server.Close)
+	endpoint := Endpoint{URL: server.URL, PathStyle: true}
+	creds := credentials.NewStore(&credentials.Static{AccessKeyId: "KEY", AccessSecretKey: "SECRET"}, 0)
+	s3, err := New("bucket", "/prefix", "us-east-1", 5, endpoint, Multipart{}, Signing{Streaming: true}, creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allocated := func(size int64) uint64 {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		if _, err := s3.Put("object", &zeroReader{size: size}, -1, http.Header{}, httptest.NewRecorder()); err != nil {
+			t.Fatal(err)
+		}
+		runtime.ReadMemStats(&after)
+		return after.TotalAlloc - before.TotalAlloc
+	}
+
+	small, large := allocated(24<<20), allocated(96<<20)
+	if parts != 15 {
+		t.Errorf("expected 3 then 12 parts, found %d", parts)
+	}
+	// Four times the payload, still a single part of it in memory at a time: what grows is the
+	// signature of each chunk, a small fraction of the 72 MiB more.
+	if large > small+9<<20 {
+		t.Errorf("expected the memory used not to grow with the payload, %d bytes allocated for 24 MiB, %d for 96 MiB", small, large)
+	}
+}
+
+func TestPutMultipartAbort(t *testing.T) {
+	fake, s3 := newFakeS3(t)
+	fake.failPart = 2
+	_, err := s3.Put("object", strings.NewReader(strings.Repeat("x", 40<<20)), -1, http.Header{}, httptest.NewRecorder())
+	if err == nil {
+		t.Fatal("expected the upload to fail")
+	}
+	if fake.aborted != 1 || len(fake.uploads) != 0 {
+		t.Errorf("expected the upload to be aborted, aborted: %d, pending: %d", fake.aborted, len(fake.uploads))
+	}
+	if len(fake.objects) != 0 {
+		t.Errorf("expected no object, found %d", len(fake.objects))
+	}
+}
+
+func TestDelete(t *testing.T) {
+	fake, s3 := newFakeS3(t)
+	fake.objects["/bucket/prefix/object"] = []byte("payload")
+	if err := s3.Delete("object", httptest.NewRecorder()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.objects["/bucket/prefix/object"]; ok {
+		t.Error("expected the object to be deleted")
+	}
+	req := httptest.NewRequest(http.MethodGet, "/object", nil)
+	if err := s3.Get("object", req, httptest.NewRecorder()); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("expected %v, found %v", service.ErrNotFound, err)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	testCases := []struct {
+		header   string
+		expected []byteRange
+		invalid  bool
+	}{
+		{header: "bytes=0-9", expected: []byteRange{{0, 9}}},
+		{header: "bytes=5-", expected: []byteRange{{5, 19}}},
+		{header: "bytes=-5", expected: []byteRange{{15, 19}}},
+		{header: "bytes=-50", expected: []byteRange{{0, 19}}},
+		{header: "bytes=10-50", expected: []byteRange{{10, 19}}},
+		{header: "bytes=0-1, 4-5,-2", expected: []byteRange{{0, 1}, {4, 5}, {18, 19}}},
+		{header: "bytes=0-1,30-40", expected: []byteRange{{0, 1}}},
+		{header: "bytes=30-40", invalid: true},
+		{header: "bytes=5-2", invalid: true},
+		{header: "bytes=a-b", invalid: true},
+		{header: "items=0-1", invalid: true},
+	}
+
+	for _, tt := range testCases {
+		ranges, err := parseRange(tt.header, 20)
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("%q: expected an error, found %v", tt.header, ranges)
+			}
+			continue
+		}
+		if err != nil || fmt.Sprint(ranges) != fmt.Sprint(tt.expected) {
+			t.Errorf("%q: expected %v, found %v, %v", tt.header, tt.expected, ranges, err)
+		}
+	}
+}
+
+func TestWorthServing(t *testing.T) {
+	var ranges []byteRange
+	for i := int64(0); i < maxRanges; i++ {
+		ranges = append(ranges, byteRange{start: 2 * i, end: 2 * i})
+	}
+	if !worthServing(ranges, 100) {
+		t.Errorf("expected %d distinct ranges to be served", maxRanges)
+	}
+	if worthServing(append(ranges, byteRange{start: 90, end: 91}), 100) {
+		t.Errorf("expected more than %d ranges to be served as the whole object", maxRanges)
+	}
+	if worthServing([]byteRange{{10, 20}, {0, 10}}, 100) {
+		t.Error("expected overlapping ranges to be served as the whole object")
+	}
+}
+
+func TestGetRange(t *testing.T) {
+	testCases := []struct {
+		name     string
+		header   string
+		status   int
+		expected []string
+		err      error
+	}{
+		{name: "single range", header: "bytes=2-5", status: http.StatusPartialContent, expected: []string{"2345"}},
+		{name: "multiple ranges", header: "bytes=0-1,-2", status: http.StatusPartialContent, expected: []string{"01", "89"}},
+		{name: "unsatisfiable", header: "bytes=20-30", err: service.ErrRangeNotSatisfiable},
+		{name: "unsatisfiable ranges", header: "bytes=20-30,40-", err: service.ErrRangeNotSatisfiable},
+		{name: "invalid ranges", header: "bytes=0-1,a-b", status: http.StatusOK, expected: []string{"0123456789"}},
+		{name: "overlapping ranges", header: "bytes=0-5,3-8", status: http.StatusOK, expected: []string{"0123456789"}},
+		{name: "repeated ranges", header: "bytes=" + strings.Repeat("0-0,", 1000) + "1-1", status: http.StatusOK, expected: []string{"0123456789"}},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, s3 := newFakeS3(t)
+			fake.objects["/bucket/prefix/object"] = []byte("0123456789")
+			req := httptest.NewRequest(http.MethodGet, "/object", nil)
+			req.Header.Set("Range", tt.header)
+			rw := httptest.NewRecorder()
+			err := s3.Get("object", req, rw)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("expected %v, found %v", tt.err, err)
+				}
+				if contentRange := rw.Header().Get("Content-Range"); contentRange != "bytes */10" {
+					t.Errorf("expected the size of the object in Content-Range, found %q", contentRange)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rw.Code != tt.status {
+				t.Errorf("expected status %d, found %d", tt.status, rw.Code)
+			}
+			mediaType, params, _ := mime.ParseMediaType(rw.Header().Get("Content-Type"))
+			if mediaType != "multipart/byteranges" {
+				if rw.Body.String() != tt.expected[0] {
+					t.Errorf("expected %q, found %q", tt.expected[0], rw.Body.String())
+				}
+				return
+			}
+			reader := multipart.NewReader(rw.Body, params["boundary"])
+			for _, expected := range tt.expected {
+				part, err := reader.NextPart()
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ := io.ReadAll(part)
+				if string(body) != expected {
+					t.Errorf("expected part %q, found %q (%s)", expected, body, part.Header.Get("Content-Range"))
+				}
+			}
+		})
+	}
+}
+
+func TestConditional(t *testing.T) {
+	fake, s3 := newFakeS3(t)
+	fake.objects["/bucket/prefix/object"] = []byte("payload")
+
+	header := http.Header{"If-None-Match": {"*"}}
+	_, err := s3.Put("object", strings.NewReader("overwrite"), 9, header, httptest.NewRecorder())
+	if !errors.Is(err, service.ErrPreconditionFailed) {
+		t.Errorf("PUT If-None-Match *: expected %v, found %v", service.ErrPreconditionFailed, err)
+	}
+	if string(fake.objects["/bucket/prefix/object"]) != "payload" {
+		t.Errorf("PUT If-None-Match *: the object was overwritten")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/object", nil)
+	req.Header.Set("If-None-Match", `"etag"`)
+	rw := httptest.NewRecorder()
+	if err = s3.Get("object", req, rw); err != nil || rw.Code != http.StatusNotModified {
+		t.Errorf("GET If-None-Match: expected status %d, found %d, %v", http.StatusNotModified, rw.Code, err)
+	}
+
+	req = httptest.NewRequest(http.MethodHead, "/object", nil)
+	req.Header.Set("If-None-Match", `"etag"`)
+	if err = s3.Head("object", req, httptest.NewRecorder()); !errors.Is(err, service.ErrNotModified) {
+		t.Errorf("HEAD If-None-Match: expected %v, found %v", service.ErrNotModified, err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/object", nil)
+	req.Header.Set("If-Match", `"other"`)
+	if err = s3.Get("object", req, httptest.NewRecorder()); !errors.Is(err, service.ErrPreconditionFailed) {
+		t.Errorf("GET If-Match: expected %v, found %v", service.ErrPreconditionFailed, err)
+	}
+}
+
+func TestExpiredToken(t *testing.T) {
+	fake, s3 := newFakeS3(t)
+	fake.expired = 1
+	if _, err := s3.Put("object", strings.NewReader("payload"), -1, http.Header{}, httptest.NewRecorder()); err != nil {
+		t.Fatal(err)
+	}
+	if string(fake.objects["/bucket/prefix/object"]) != "payload" {
+		t.Errorf("expected the payload to be sent again, found %q", fake.objects["/bucket/prefix/object"])
+	}
+
+	fake.expired = 2
+	_, err := s3.Put("object", strings.NewReader("payload"), -1, http.Header{}, httptest.NewRecorder())
+	var s3Err *Error
+	if !errors.As(err, &s3Err) || s3Err.Code != "ExpiredToken" {
+		t.Errorf("expected a single retry, found %v", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	fake, s3 := newFakeS3(t)
+	fake.objects["/bucket/prefix/object"] = []byte("payload")
+	fake.objects["/bucket/other/object"] = []byte("other")
+	listing, err := s3.List(service.ListOptions{Prefix: "obj", Delimiter: "/", MaxKeys: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listing.Prefix != "obj" || listing.MaxKeys != 10 {
+		t.Errorf("unexpected listing %+v", listing)
+	}
+	if len(listing.Contents) != 1 || listing.Contents[0].Key != "object" || listing.Contents[0].Size != 7 || listing.Contents[0].LastModified.Year() != 2024 {
+		t.Errorf("expected the object without the prefix, found %+v", listing.Contents)
+	}
+	if len(listing.CommonPrefixes) != 1 || listing.CommonPrefixes[0].Prefix != "dir/" {
+		t.Errorf("expected the common prefix without the prefix, found %+v", listing.CommonPrefixes)
+	}
+}
+
+func TestObjectKeyEncoding(t *testing.T) {
+	fake, s3 := newFakeS3(t)
+	var requestUri string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		requestUri = req.RequestURI
+		fake.ServeHTTP(rw, req)
+	}))
+	defer server.Close()
+	s3.bucketUri = server.URL + "/bucket"
+
+	name := "my photo+1 ሴ?.txt"
+	if _, err := s3.Put(name, strings.NewReader("payload"), -1, http.Header{}, httptest.NewRecorder()); err != nil {
+		t.Fatal(err)
+	}
+	if requestUri != "/bucket/prefix/my%20photo%2B1%20%E1%88%B4%3F.txt" {
+		t.Errorf("expected the key to be encoded as signed, found %s", requestUri)
+	}
+	if string(fake.objects["/bucket/prefix/"+name]) != "payload" {
+		t.Errorf("expected the object to be stored under its key, found %v", fake.objects)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		status   int
+		body     string
+		expected error
+	}{
+		{name: "no such key", status: http.StatusNotFound, body: "<Error><Code>NoSuchKey</Code></Error>", expected: service.ErrNotFound},
+		{name: "access denied", status: http.StatusForbidden, body: "<Error><Code>AccessDenied</Code></Error>", expected: service.ErrAccessDenied},
+		{name: "too large", status: http.StatusBadRequest, body: "<Error><Code>EntityTooLarge</Code></Error>", expected: service.ErrTooLarge},
+		{name: "slow down", status: http.StatusServiceUnavailable, body: "<Error><Code>SlowDown</Code></Error>", expected: service.ErrThrottled},
+		{name: "bad request", status: http.StatusBadRequest, body: "<Error><Code>InvalidArgument</Code></Error>", expected: service.ErrBadRequest},
+		{name: "no body", status: http.StatusPreconditionFailed, expected: service.ErrPreconditionFailed},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.WriteHeader(tt.status)
+				fmt.Fprint(rw, tt.body)
+			}))
+			defer server.Close()
+			_, s3 := newFakeS3(t)
+			s3.bucketUri = server.URL + "/bucket"
+			err := s3.Get("object", httptest.NewRequest(http.MethodGet, "/object", nil), httptest.NewRecorder())
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("expected %v, found %v", tt.expected, err)
+			}
+			var s3Err *Error
+			if !errors.As(err, &s3Err) || s3Err.StatusCode != tt.status {
+				t.Errorf("expected an S3 error with status %d, found %v", tt.status, err)
+			}
+		})
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	testCases := []struct {
+		name     string
+		endpoint Endpoint
+		region   string
+		expected string
+	}{
+		{name: "global", expected: "https://bucket.s3.amazonaws.com"},
+		{name: "regional", endpoint: Endpoint{Regional: true}, region: "eu-west-3", expected: "https://bucket.s3.eu-west-3.amazonaws.com"},
+		{name: "regional path style", endpoint: Endpoint{Regional: true, PathStyle: true}, region: "eu-west-3", expected: "https://s3.eu-west-3.amazonaws.com/bucket"},
+		{name: "custom", endpoint: Endpoint{URL: "https://s3.dualstack.us-east-2.amazonaws.com/"}, expected: "https://bucket.s3.dualstack.us-east-2.amazonaws.com"},
+		{name: "custom path style", endpoint: Endpoint{URL: "http://minio:9000", PathStyle: true}, expected: "http://minio:9000/bucket"},
+	}
+
+	for _, tt := range testCases {
+		uri, err := tt.endpoint.bucketUri("bucket", tt.region)
+		if err != nil || uri != tt.expected {
+			t.Errorf("%s: expected %q, found %q, %v", tt.name, tt.expected, uri, err)
+		}
+	}
+
+	for _, endpoint := range []Endpoint{{URL: "minio:9000"}, {URL: "/path"}, {Regional: true}} {
+		if uri, err := endpoint.bucketUri("bucket", ""); err == nil {
+			t.Errorf("%v: expected an error, found %q", endpoint, uri)
+		}
+	}
+}
+
+func TestAccessPoint(t *testing.T) {
+	mrap, err := New("arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap", "", "us-east-1", 5, Endpoint{}, Multipart{}, Signing{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mrap.bucketUri != "https://mfzwi23gnjvgw.mrap.accesspoint.s3-global.amazonaws.com" {
+		t.Errorf("unexpected multi-region access point URI %s", mrap.bucketUri)
+	}
+	if len(mrap.crTemplate.RegionSet) != 1 || mrap.crTemplate.RegionSet[0] != "*" {
+		t.Errorf("expected SigV4a for all regions, found %v", mrap.crTemplate.RegionSet)
+	}
+
+	restricted, err := New("arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap", "", "us-east-1", 5, Endpoint{}, Multipart{}, Signing{RegionSet: []string{"eu-west-3"}}, nil)
+	if err != nil || len(restricted.crTemplate.RegionSet) != 1 || restricted.crTemplate.RegionSet[0] != "eu-west-3" {
+		t.Errorf("expected the configured region set, found %v, %v", restricted.crTemplate.RegionSet, err)
+	}
+
+	regional, err := New("arn:aws:s3:eu-west-3:123456789012:accesspoint/photos", "", "us-east-1", 5, Endpoint{}, Multipart{}, Signing{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if regional.bucketUri != "https://photos-123456789012.s3-accesspoint.eu-west-3.amazonaws.com" || regional.crTemplate.Region != "eu-west-3" {
+		t.Errorf("unexpected access point URI %s signed for %s", regional.bucketUri, regional.crTemplate.Region)
+	}
+	if len(regional.crTemplate.RegionSet) != 0 {
+		t.Errorf("expected SigV4, found the region set %v", regional.crTemplate.RegionSet)
+	}
+
+	for _, bucket := range []string{"arn:aws:s3:::bucket", "arn:aws:sqs::123456789012:accesspoint/alias", "arn:aws:s3::123456789012:accesspoint/"} {
+		if _, err := New(bucket, "", "us-east-1", 5, Endpoint{}, Multipart{}, Signing{}, nil); err == nil {
+			t.Errorf("%s: expected an error", bucket)
+		}
+	}
+	if _, err := New("arn:aws:s3::123456789012:accesspoint/alias.mrap", "", "us-east-1", 5, Endpoint{}, Multipart{}, Signing{Streaming: true}, nil); err == nil {
+		t.Error("expected streaming signatures to be refused with SigV4a")
+	}
+}
@@ -0,0 +1,86 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+)
+
+// Payloads up to this size are kept in memory, larger ones are spooled to a temporary file.
+const maxMemoryPayload = 1 << 20
+
+// spooledPayload is a request body whose size and SHA256 are known before it is sent,
+// as required to sign it. Payloads signed as they are streamed have no hash.
+type spooledPayload struct {
+	io.Reader
+	size int64
+	hash string
+	file *os.File
+}
+
+// spool reads payload to the end, hashing it on the way, so that it can be signed and
+// replayed to S3 without holding more than maxMemoryPayload bytes in memory.
+// contentLength is -1 when unknown.
+func spool(payload io.Reader, contentLength int64) (*spooledPayload, error) {
+	sha := sha256.New()
+	buf := &bytes.Buffer{}
+	var n int64
+	if contentLength <= maxMemoryPayload {
+		var err error
+		n, err = io.CopyN(io.MultiWriter(buf, sha), payload, maxMemoryPayload+1)
+		if err == io.EOF {
+			return &spooledPayload{
+				Reader: bytes.NewReader(buf.Bytes()),
+				size:   n,
+				hash:   hex.EncodeToString(sha.Sum(nil)),
+			}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.CreateTemp("", "traefik-aws-plugin-*")
+	if err != nil {
+		return nil, err
+	}
+	spooled := &spooledPayload{Reader: file, file: file}
+	if _, err = buf.WriteTo(file); err != nil {
+		spooled.Close()
+		return nil, err
+	}
+	rest, err := io.Copy(io.MultiWriter(file, sha), payload)
+	if err != nil {
+		spooled.Close()
+		return nil, err
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		spooled.Close()
+		return nil, err
+	}
+	spooled.size = n + rest
+	spooled.hash = hex.EncodeToString(sha.Sum(nil))
+	return spooled, nil
+}
+
+// rewind replays the payload from the start, to send it again.
+func (spooled *spooledPayload) rewind() error {
+	seeker, ok := spooled.Reader.(io.Seeker)
+	if !ok {
+		return errors.New("a streamed payload cannot be sent again")
+	}
+	_, err := seeker.Seek(0, io.SeekStart)
+	return err
+}
+
+func (spooled *spooledPayload) Close() error {
+	if spooled.file == nil {
+		return nil
+	}
+	err := spooled.file.Close()
+	os.Remove(spooled.file.Name())
+	return err
+}
@@ -0,0 +1,16 @@
+package service
+
+import "errors"
+
+// Errors returned by the services, wrapped with details, which the plugin turns into HTTP statuses.
+var (
+	ErrNotFound            = errors.New("not found")
+	ErrAccessDenied        = errors.New("access denied")
+	ErrBadRequest          = errors.New("bad request")
+	ErrTooLarge            = errors.New("entity too large")
+	ErrThrottled           = errors.New("throttled")
+	ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+	ErrNotModified         = errors.New("not modified")
+	ErrPreconditionFailed  = errors.New("precondition failed")
+	ErrNotImplemented      = errors.New("not implemented")
+)
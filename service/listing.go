@@ -0,0 +1,49 @@
+package service
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"time"
+)
+
+// MaxKeys is the largest number of keys a listing returns, as with S3.
+const MaxKeys = 1000
+
+// ListOptions select the objects to list, as the parameters of ListObjectsV2 do.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_ListObjectsV2.html
+type ListOptions struct {
+	Prefix            string
+	Delimiter         string
+	ContinuationToken string
+	StartAfter        string
+	MaxKeys           int
+}
+
+// Listing is a page of objects, shaped after the ListObjectsV2 result so that the S3 response
+// can be read as is. Keys sharing a prefix up to the delimiter are rolled up into CommonPrefixes.
+// Services storing JSON documents list the documents themselves in Items, in the order of Contents.
+type Listing struct {
+	XMLName               xml.Name          `json:"-" xml:"ListBucketResult"`
+	Prefix                string            `json:"prefix" xml:"Prefix"`
+	Delimiter             string            `json:"delimiter,omitempty" xml:"Delimiter,omitempty"`
+	StartAfter            string            `json:"startAfter,omitempty" xml:"StartAfter,omitempty"`
+	MaxKeys               int               `json:"maxKeys" xml:"MaxKeys"`
+	KeyCount              int               `json:"keyCount" xml:"KeyCount"`
+	IsTruncated           bool              `json:"isTruncated" xml:"IsTruncated"`
+	ContinuationToken     string            `json:"continuationToken,omitempty" xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string            `json:"nextContinuationToken,omitempty" xml:"NextContinuationToken,omitempty"`
+	Contents              []Object          `json:"contents" xml:"Contents"`
+	CommonPrefixes        []CommonPrefix    `json:"commonPrefixes" xml:"CommonPrefixes"`
+	Items                 []json.RawMessage `json:"items,omitempty" xml:"-"`
+}
+
+type Object struct {
+	Key          string    `json:"key" xml:"Key"`
+	Size         int64     `json:"size" xml:"Size"`
+	ETag         string    `json:"etag,omitempty" xml:"ETag,omitempty"`
+	LastModified time.Time `json:"lastModified" xml:"LastModified"`
+}
+
+type CommonPrefix struct {
+	Prefix string `json:"prefix" xml:"Prefix"`
+}
@@ -0,0 +1,107 @@
+package signer
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha256"
+	"sync"
+)
+
+// maxCachedKeys bounds the signing keys kept, a handful per set of credentials in use.
+const maxCachedKeys = 256
+
+// signingKeys caches the signing keys, valid for a day, region and service, so that the four
+// HMACs of their derivation are not computed for every request.
+var signingKeys = &keyCache{keys: map[keyScope][]byte{}}
+
+// keyScope identifies a signing key. The secret is part of it: once credentials are rotated,
+// the keys derived from the former secret are no longer looked up, and dropped as the cache
+// fills up or the day changes.
+type keyScope struct {
+	secret  string
+	day     string
+	region  string
+	service string
+}
+
+type keyCache struct {
+	mu   sync.Mutex
+	keys map[keyScope][]byte
+}
+
+func (cache *keyCache) get(scope keyScope) []byte {
+	cache.mu.Lock()
+	key, ok := cache.keys[scope]
+	cache.mu.Unlock()
+	if ok {
+		return key
+	}
+	key = deriveSigningKey(scope)
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if len(cache.keys) >= maxCachedKeys {
+		for cached := range cache.keys {
+			if cached.day != scope.day {
+				delete(cache.keys, cached)
+			}
+		}
+		if len(cache.keys) >= maxCachedKeys {
+			cache.keys = map[keyScope][]byte{}
+		}
+	}
+	cache.keys[scope] = key
+	return key
+}
+
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html#derive-signing-key
+func deriveSigningKey(scope keyScope) []byte {
+	dateKey := hmac.New(sha256.New, []byte("AWS4"+scope.secret))
+	dateKey.Write([]byte(scope.day))
+
+	dateRegionKey := hmac.New(sha256.New, dateKey.Sum(nil))
+	dateRegionKey.Write([]byte(scope.region))
+
+	dateRegionServiceKey := hmac.New(sha256.New, dateRegionKey.Sum(nil))
+	dateRegionServiceKey.Write([]byte(scope.service))
+
+	signingKey := hmac.New(sha256.New, dateRegionServiceKey.Sum(nil))
+	signingKey.Write([]byte("aws4_request"))
+	return signingKey.Sum(nil)
+}
+
+// keysV4a caches the SigV4a keys, which unlike signing keys do not depend on the day, so that
+// the KDF and the scalar multiplication of their derivation run once per access key pair.
+var keysV4a = &keyCacheV4a{keys: map[accessKeyPair]*ecdsa.PrivateKey{}}
+
+type accessKeyPair struct {
+	accessKeyId string
+	secret      string
+}
+
+type keyCacheV4a struct {
+	mu   sync.Mutex
+	keys map[accessKeyPair]*ecdsa.PrivateKey
+}
+
+func (cache *keyCacheV4a) get(pair accessKeyPair) (*ecdsa.PrivateKey, error) {
+	cache.mu.Lock()
+	key, ok := cache.keys[pair]
+	cache.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	key, err := deriveKeyV4a(pair.accessKeyId, pair.secret)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if len(cache.keys) >= maxCachedKeys {
+		// Rotated credentials leave their keys behind, there is no day to tell them apart.
+		cache.keys = map[accessKeyPair]*ecdsa.PrivateKey{}
+	}
+	cache.keys[pair] = key
+	return key, nil
+}
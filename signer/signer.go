@@ -5,35 +5,47 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
-	"github.com/bluecatengineering/traefik-aws-plugin/ecs"
+	"github.com/bluecatengineering/traefik-aws-plugin/credentials"
 	"net/http"
 	"net/url"
+	"path"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
 // https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
 type CanonRequest struct {
-	// ecs
-	Creds   *ecs.Credentials
+	// credentials
+	Creds   *credentials.Credentials
 	Region  string
 	Service string
+	// SignedHeaders are signed on top of host, content-type, content-md5, range and the x-amz-*
+	// headers. Other headers are left out of the signature, so that the transport or a proxy
+	// may change them on the way.
+	SignedHeaders []string
+	// RegionSet switches to SigV4a, the signature being valid in these regions, "*" for all of them.
+	RegionSet []string
 
 	// V4 data
 	httpMethod  string
 	date        string
-	queryParams map[string]string
+	queryParams url.Values
 	amzHeaders  map[string]string
 	canonUri    string
+	payloadHash string
 }
 
 // https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html#create-canonical-request
 func (cr *CanonRequest) RequestString() string {
-	queryString := canonString(cr.queryParams, "=", "&", true)
-	headers := canonString(cr.amzHeaders, ":", "\n", false)
+	queryString := CanonicalQuery(cr.queryParams)
+	headers := canonString(cr.amzHeaders, ":", "\n")
 	signedHeaders := strings.Join(sortedKeys(cr.amzHeaders), ";")
-	hashedPayload := cr.amzHeaders["x-amz-content-sha256"]
+	hashedPayload := cr.payloadHash
+	if hashedPayload == "" {
+		hashedPayload = cr.amzHeaders["x-amz-content-sha256"]
+	}
 	return fmt.Sprintf("%s\n%s\n%s\n%s\n\n%s\n%s", cr.httpMethod, cr.canonUri, queryString, headers, signedHeaders, hashedPayload)
 }
 
@@ -63,26 +75,22 @@ func (cr *CanonRequest) SignatureV4() string {
 		date = amzDate
 	}
 
-	dateKey := hmac.New(sha256.New, []byte("AWS4"+cr.Creds.AccessSecretKey))
-	dateKey.Write([]byte(date[:8]))
-
-	dateRegionKey := hmac.New(sha256.New, dateKey.Sum(nil))
-	dateRegionKey.Write([]byte(cr.Region))
-
-	dateRegionServiceKey := hmac.New(sha256.New, dateRegionKey.Sum(nil))
-	dateRegionServiceKey.Write([]byte(cr.Service))
-
-	signingKey := hmac.New(sha256.New, dateRegionServiceKey.Sum(nil))
-	signingKey.Write([]byte("aws4_request"))
-
-	signatureV4 := hmac.New(sha256.New, signingKey.Sum(nil))
+	signatureV4 := hmac.New(sha256.New, cr.signingKey(date[:8]))
 	signatureV4.Write([]byte(cr.StringToSignV4()))
 
 	return hex.EncodeToString(signatureV4.Sum(nil))
 }
 
+// signingKey is the key of the day, region and service, derived from the secret access key.
+func (cr *CanonRequest) signingKey(day string) []byte {
+	return signingKeys.get(keyScope{secret: cr.Creds.AccessSecretKey, day: day, region: cr.Region, service: cr.Service})
+}
+
 // https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html#add-signature-to-request
 func (cr *CanonRequest) AuthHeader() string {
+	if len(cr.RegionSet) > 0 {
+		return cr.authHeaderV4a()
+	}
 	date := cr.date
 	if amzDate, ok := cr.amzHeaders["x-amz-date"]; ok {
 		date = amzDate
@@ -93,8 +101,26 @@ func (cr *CanonRequest) AuthHeader() string {
 		",Signature=" + cr.SignatureV4()
 }
 
-func CreateCanonRequest(req *http.Request, payload []byte, crTemplate CanonRequest) *CanonRequest {
-	now := time.Now()
+// EmptyPayloadHash is the hex encoded SHA256 of an empty payload.
+const EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// UnsignedPayload replaces the payload hash when the payload is not part of the signature,
+// as with presigned URLs.
+const UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// HashPayload returns the hex encoded SHA256 of a payload held in memory.
+func HashPayload(payload []byte) string {
+	sha := sha256.Sum256(payload)
+	return hex.EncodeToString(sha[:])
+}
+
+// CreateCanonRequest signs req for a payload whose hex encoded SHA256 is payloadHash.
+// The hash is taken as a parameter so callers can compute it while streaming the payload.
+func CreateCanonRequest(req *http.Request, payloadHash string, crTemplate CanonRequest) *CanonRequest {
+	return createCanonRequest(req, payloadHash, crTemplate, time.Now())
+}
+
+func createCanonRequest(req *http.Request, payloadHash string, crTemplate CanonRequest, now time.Time) *CanonRequest {
 	formatted := strings.ReplaceAll(
 		strings.ReplaceAll(now.UTC().Format(time.RFC3339), "-", ""),
 		":", "")
@@ -102,36 +128,170 @@ func CreateCanonRequest(req *http.Request, payload []byte, crTemplate CanonReque
 	if date := req.Header.Get("date"); date == "" {
 		req.Header.Set("date", now.Local().Format(time.RFC1123))
 	}
-	crPayload := payload
-	if crPayload == nil {
-		crPayload = []byte("")
-	}
-	sha := sha256.New()
-	sha.Write(crPayload)
-	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sha.Sum(nil)))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
 	req.Header.Set("X-Amz-Date", amzDate)
 	if crTemplate.Creds.SecurityToken != "" {
 		req.Header.Set("x-amz-security-token", crTemplate.Creds.SecurityToken)
 	}
+	if len(crTemplate.RegionSet) > 0 {
+		req.Header.Set("X-Amz-Region-Set", strings.Join(crTemplate.RegionSet, ","))
+	}
 	return updateCanonRequest(req, &crTemplate)
 }
 
+// Presign returns the URL of req signed in its query string, which anyone holding it can use
+// until expires elapses, up to 7 days. Only the host header is signed, the payload is not.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-query-string-auth.html
+func Presign(req *http.Request, expires time.Duration, crTemplate CanonRequest) *url.URL {
+	return presign(req, expires, crTemplate, time.Now())
+}
+
+func presign(req *http.Request, expires time.Duration, crTemplate CanonRequest, now time.Time) *url.URL {
+	cr := &crTemplate
+	amzDate := now.UTC().Format("20060102T150405Z")
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	query := req.URL.Query()
+	if len(cr.RegionSet) > 0 {
+		query.Set("X-Amz-Algorithm", AlgorithmV4a)
+		query.Set("X-Amz-Credential", cr.Creds.AccessKeyId+"/"+cr.scopeV4a(amzDate))
+		query.Set("X-Amz-Region-Set", strings.Join(cr.RegionSet, ","))
+	} else {
+		query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+		query.Set("X-Amz-Credential", cr.Creds.AccessKeyId+"/"+amzDate[:8]+"/"+cr.Region+"/"+cr.Service+"/aws4_request")
+	}
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if cr.Creds.SecurityToken != "" {
+		query.Set("X-Amz-Security-Token", cr.Creds.SecurityToken)
+	}
+
+	cr.httpMethod = req.Method
+	cr.date = amzDate
+	cr.amzHeaders = map[string]string{"host": host}
+	cr.queryParams = query
+	cr.canonUri = canonicalUri(req.URL.Path, cr.Service)
+	cr.payloadHash = UnsignedPayload
+	signature := cr.SignatureV4()
+	if len(cr.RegionSet) > 0 {
+		// Left unsigned should no key be derived, the URL is rejected by AWS.
+		signature, _ = cr.SignatureV4a()
+	}
+
+	presigned := *req.URL
+	presigned.RawQuery = CanonicalQuery(query) + "&X-Amz-Signature=" + signature
+	return &presigned
+}
+
 func updateCanonRequest(req *http.Request, cr *CanonRequest) *CanonRequest {
 	m := map[string][]string(req.Header)
-	headers := make(map[string]string, len(m))
+	headers := make(map[string]string, len(m)+1)
 	for k, vs := range m {
-		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(vs, ","))
+		if !cr.signs(strings.ToLower(k)) {
+			continue
+		}
+		values := make([]string, len(vs))
+		for i, v := range vs {
+			// Sequential spaces are folded into one, leading and trailing ones are dropped.
+			values[i] = strings.Join(strings.Fields(v), " ")
+		}
+		headers[strings.ToLower(k)] = strings.Join(values, ",")
+	}
+	if headers["host"] == "" {
+		headers["host"] = req.Host
+		if headers["host"] == "" {
+			headers["host"] = req.URL.Host
+		}
 	}
 	cr.httpMethod = req.Method
-	cr.date = headers["date"]
+	cr.date = req.Header.Get("date")
 	cr.amzHeaders = headers
-	if req.URL.Path != "" {
-		cr.canonUri = strings.TrimSpace(req.URL.Path)
-	}
+	cr.queryParams = req.URL.Query()
+	cr.canonUri = canonicalUri(req.URL.Path, cr.Service)
 	return cr
 }
 
-func canonString(in map[string]string, sep string, inter string, encoding bool) string {
+// signs tells whether the lower case header is part of the signature.
+func (cr *CanonRequest) signs(header string) bool {
+	switch header {
+	case "host", "content-type", "content-md5", "range":
+		return true
+	}
+	if strings.HasPrefix(header, "x-amz-") {
+		return true
+	}
+	for _, signed := range cr.SignedHeaders {
+		if strings.EqualFold(signed, header) {
+			return true
+		}
+	}
+	return false
+}
+
+// Services whose URI paths are left as is, where the other services expect them normalized.
+var unnormalizedServices = map[string]bool{"s3": true}
+
+// canonicalUri encodes the decoded path of a request once, as the SigV4 test suite does. The
+// services other than S3 encode the path twice: a request to one of them with a path other
+// than / is to be signed with that path encoded already, which this plugin never sends.
+func canonicalUri(uriPath string, service string) string {
+	if uriPath == "" {
+		return "/"
+	}
+	if unnormalizedServices[service] {
+		return URIEncode(uriPath, false)
+	}
+	normalized := path.Clean(uriPath)
+	if strings.HasSuffix(uriPath, "/") && normalized != "/" {
+		normalized += "/"
+	}
+	return URIEncode(normalized, false)
+}
+
+// URIEncode percent-encodes the bytes of s, but for the unreserved characters of RFC 3986,
+// and the slashes unless encodeSlash is set, as SigV4 requires.
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html#create-canonical-request
+func URIEncode(s string, encodeSlash bool) string {
+	var encoded strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			encoded.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&encoded, "%%%02X", c)
+	}
+	return encoded.String()
+}
+
+// CanonicalQuery encodes query parameters for SigV4, sorted by name then value. Every value of
+// a parameter is kept, empty ones included. The result can be sent as the query of the request.
+func CanonicalQuery(query url.Values) string {
+	type param struct{ key, value string }
+	params := make([]param, 0, len(query))
+	for k, vs := range query {
+		for _, v := range vs {
+			params = append(params, param{key: URIEncode(k, true), value: URIEncode(v, true)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i].key != params[j].key {
+			return params[i].key < params[j].key
+		}
+		return params[i].value < params[j].value
+	})
+	pairs := make([]string, len(params))
+	for i, p := range params {
+		pairs[i] = p.key + "=" + p.value
+	}
+	return strings.Join(pairs, "&")
+}
+
+func canonString(in map[string]string, sep string, inter string) string {
 	var c string
 	keys := make([]string, 0, len(in))
 	for k := range in {
@@ -142,11 +302,7 @@ func canonString(in map[string]string, sep string, inter string, encoding bool)
 		if c != "" {
 			c = c + inter
 		}
-		if encoding {
-			c = c + fmt.Sprintf("%s%s%s", url.QueryEscape(k), sep, url.QueryEscape(in[k]))
-		} else {
-			c = c + fmt.Sprintf("%s%s%s", k, sep, in[k])
-		}
+		c = c + fmt.Sprintf("%s%s%s", k, sep, in[k])
 	}
 	return c
 }
@@ -0,0 +1,57 @@
+package signer
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/credentials"
+)
+
+// The key pair of the SigV4a test vectors of the AWS SDKs.
+func TestDeriveKeyV4a(t *testing.T) {
+	key, err := deriveKeyV4a("AKISORANDOMAASORANDOM", "q+jcrXGc+0zWN6uzclKVhvMmUsIfRPa4rlRandom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, y := fmt.Sprintf("%064X", key.X), fmt.Sprintf("%064X", key.Y)
+	if x != "15D242CEEBF8D8169FD6A8B5A746C41140414C3B07579038DA06AF89190FFFCB" ||
+		y != "0515242CEDD82E94799482E4C0514B505AFCCF2C0C98D6A553BF539F424C5EC0" {
+		t.Errorf("unexpected public key (%s, %s)", x, y)
+	}
+}
+
+func TestSignV4a(t *testing.T) {
+	crTemplate := CanonRequest{
+		Creds:     &credentials.Credentials{AccessKeyId: "KEY", AccessSecretKey: "SECRET"},
+		Region:    "us-east-1",
+		Service:   "s3",
+		RegionSet: []string{"us-east-1", "eu-west-3"},
+	}
+	req, _ := http.NewRequest(http.MethodGet, "https://mfzwi23gnjvgw.mrap.accesspoint.s3-global.amazonaws.com/object", nil)
+	req.Header.Set("Host", req.URL.Host)
+	cr := createCanonRequest(req, EmptyPayloadHash, crTemplate, time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	if regionSet := req.Header.Get("X-Amz-Region-Set"); regionSet != "us-east-1,eu-west-3" {
+		t.Errorf("expected the region set header, found %q", regionSet)
+	}
+	authHeader := cr.AuthHeader()
+	prefix := "AWS4-ECDSA-P256-SHA256 Credential=KEY/20220601/s3/aws4_request,SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-region-set,Signature="
+	if !strings.HasPrefix(authHeader, prefix) {
+		t.Fatalf("unexpected authorization header %s", authHeader)
+	}
+	signature, err := hex.DecodeString(strings.TrimPrefix(authHeader, prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := deriveKeyV4a("KEY", "SECRET")
+	digest := sha256.Sum256([]byte(cr.StringToSignV4a()))
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], signature) {
+		t.Errorf("signature does not verify\nstring to sign:\n%s", cr.StringToSignV4a())
+	}
+}
@@ -0,0 +1,176 @@
+package signer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Payload hashes announcing a body sent in aws-chunked encoding, each chunk signed in turn
+// or followed by a trailing checksum.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-streaming.html
+const (
+	StreamingPayload                = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	StreamingUnsignedPayloadTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+)
+
+// DefaultChunkSize is the size of the chunks of a streamed payload, S3 requires at least 8 KiB.
+const DefaultChunkSize = 64 << 10
+
+const chunkSignatureLength = len(";chunk-signature=") + sha256.Size*2
+
+// Checksums S3 verifies, by the name of their algorithm.
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/checking-object-integrity.html
+var checksums = map[string]func() hash.Hash{
+	"crc32":  func() hash.Hash { return crc32.NewIEEE() },
+	"crc32c": func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// NewChecksum returns the hash of a checksum algorithm S3 supports: crc32, crc32c, sha1 or sha256.
+func NewChecksum(algorithm string) (hash.Hash, error) {
+	newHash, ok := checksums[strings.ToLower(algorithm)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+	return newHash(), nil
+}
+
+// ChecksumHeader is the header carrying the checksum of a payload computed with algorithm.
+func ChecksumHeader(algorithm string) string {
+	return "x-amz-checksum-" + strings.ToLower(algorithm)
+}
+
+// SignStreaming signs req and replaces its body, of decodedLength bytes, by its aws-chunked
+// encoding, where each chunk is signed with the signature of the previous one as it is read.
+// Nothing but the current chunk is held in memory.
+func SignStreaming(req *http.Request, decodedLength int64, chunkSize int, crTemplate CanonRequest) *CanonRequest {
+	return signStreaming(req, decodedLength, chunkSize, crTemplate, time.Now())
+}
+
+func signStreaming(req *http.Request, decodedLength int64, chunkSize int, crTemplate CanonRequest, now time.Time) *CanonRequest {
+	setChunkedHeaders(req, decodedLength)
+	req.ContentLength = chunkedLength(decodedLength, chunkSize, func(int) int { return chunkSignatureLength }) + 2
+	cr := createCanonRequest(req, StreamingPayload, crTemplate, now)
+	date := cr.amzHeaders["x-amz-date"]
+	scope := date[:8] + "/" + cr.Region + "/" + cr.Service + "/aws4_request"
+	key := cr.signingKey(date[:8])
+	previous := cr.SignatureV4()
+	req.Body = io.NopCloser(&chunkedReader{
+		src:  req.Body,
+		data: make([]byte, chunkSize),
+		extension: func(chunk []byte) string {
+			// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-streaming.html#sigv4-chunked-body-definition
+			chunkHash := sha256.Sum256(chunk)
+			stringToSign := "AWS4-HMAC-SHA256-PAYLOAD\n" + date + "\n" + scope + "\n" + previous + "\n" +
+				EmptyPayloadHash + "\n" + hex.EncodeToString(chunkHash[:])
+			signature := hmac.New(sha256.New, key)
+			signature.Write([]byte(stringToSign))
+			previous = hex.EncodeToString(signature.Sum(nil))
+			return ";chunk-signature=" + previous
+		},
+	})
+	req.GetBody = nil
+	return cr
+}
+
+// SignUnsignedTrailer signs req, leaving its body of decodedLength bytes out of the signature, and
+// sends it in aws-chunked encoding followed by its checksum computed with algorithm on the way.
+// S3 only accepts unsigned payloads over HTTPS.
+func SignUnsignedTrailer(req *http.Request, decodedLength int64, chunkSize int, algorithm string, crTemplate CanonRequest) (*CanonRequest, error) {
+	checksum, err := NewChecksum(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	trailerName := ChecksumHeader(algorithm)
+	setChunkedHeaders(req, decodedLength)
+	req.Header.Set("X-Amz-Trailer", trailerName)
+	trailerLength := len(trailerName) + 1 + base64.StdEncoding.EncodedLen(checksum.Size()) + 2
+	req.ContentLength = chunkedLength(decodedLength, chunkSize, func(int) int { return 0 }) + int64(trailerLength) + 2
+	cr := CreateCanonRequest(req, StreamingUnsignedPayloadTrailer, crTemplate)
+	req.Body = io.NopCloser(&chunkedReader{
+		src:  io.TeeReader(req.Body, checksum),
+		data: make([]byte, chunkSize),
+		extension: func([]byte) string {
+			return ""
+		},
+		trailer: func() string {
+			return trailerName + ":" + base64.StdEncoding.EncodeToString(checksum.Sum(nil)) + "\r\n"
+		},
+	})
+	req.GetBody = nil
+	return cr, nil
+}
+
+func setChunkedHeaders(req *http.Request, decodedLength int64) {
+	req.Header.Set("Content-Encoding", "aws-chunked")
+	req.Header.Set("X-Amz-Decoded-Content-Length", strconv.FormatInt(decodedLength, 10))
+	if req.Body == nil {
+		// Even an empty payload has its final chunk.
+		req.Body = http.NoBody
+	}
+}
+
+// chunkedLength is the length of the chunks of a payload of decodedLength bytes in aws-chunked
+// encoding, up to the final empty chunk included, given the length of the extension of each.
+// The trailers and the final CRLF are left to the caller.
+func chunkedLength(decodedLength int64, chunkSize int, extension func(size int) int) int64 {
+	chunk := func(size int) int64 {
+		return int64(len(strconv.FormatInt(int64(size), 16)) + extension(size) + 2 + size + 2)
+	}
+	fullChunks := decodedLength / int64(chunkSize)
+	length := fullChunks * chunk(chunkSize)
+	if rest := int(decodedLength % int64(chunkSize)); rest > 0 {
+		length += chunk(rest)
+	}
+	// The final chunk has no data, nor the CRLF which follows it.
+	return length + chunk(0) - 2
+}
+
+// chunkedReader encodes src in aws-chunked encoding: each chunk is its size in hexadecimal
+// and an extension, followed by its data, then comes an empty chunk and the trailers.
+type chunkedReader struct {
+	src       io.Reader
+	data      []byte
+	extension func(chunk []byte) string
+	trailer   func() string
+	encoded   bytes.Buffer
+	done      bool
+}
+
+func (reader *chunkedReader) Read(p []byte) (int, error) {
+	for reader.encoded.Len() == 0 {
+		if reader.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(reader.src, reader.data)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		chunk := reader.data[:n]
+		fmt.Fprintf(&reader.encoded, "%x%s\r\n", n, reader.extension(chunk))
+		if n == 0 {
+			if reader.trailer != nil {
+				reader.encoded.WriteString(reader.trailer())
+			}
+			reader.encoded.WriteString("\r\n")
+			reader.done = true
+			continue
+		}
+		reader.encoded.Write(chunk)
+		reader.encoded.WriteString("\r\n")
+	}
+	return reader.encoded.Read(p)
+}
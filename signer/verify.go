@@ -0,0 +1,226 @@
+package signer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/credentials"
+)
+
+// DefaultMaxSkew is how far the time of a signed request may be from the time it is received,
+// as AWS allows.
+const DefaultMaxSkew = 15 * time.Minute
+
+// maxPresignExpires is the longest validity of a presigned URL.
+const maxPresignExpires = 7 * 24 * time.Hour
+
+const amzDateFormat = "20060102T150405Z"
+
+// ErrPayloadMismatch is returned when reading a verified payload whose SHA256 is not the one signed.
+var ErrPayloadMismatch = errors.New("payload does not match its signed hash")
+
+// Verifier authenticates inbound requests signed with SigV4, in their Authorization header or
+// as presigned URLs, with the secret access keys of Keys, by access key id. The region and
+// service are those of the credential scope of each request.
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_sigv.html
+type Verifier struct {
+	Keys map[string]string
+	// MaxSkew bounds the clock difference with clients, DefaultMaxSkew when zero.
+	MaxSkew time.Duration
+}
+
+// signedRequest holds what a client states about the signature of its request.
+type signedRequest struct {
+	accessKeyId   string
+	scope         []string
+	signedHeaders []string
+	signature     string
+	date          time.Time
+	expires       time.Duration
+	payloadHash   string
+}
+
+// Verify returns an error unless req is signed with one of the keys, within the allowed skew
+// or, for a presigned URL, before it expires. A payload whose hash is signed is checked as it
+// is read: reading it fails with ErrPayloadMismatch at its end if it was tampered with.
+func (verifier *Verifier) Verify(req *http.Request) error {
+	return verifier.verify(req, time.Now())
+}
+
+func (verifier *Verifier) verify(req *http.Request, now time.Time) error {
+	var signed *signedRequest
+	var err error
+	if req.Header.Get("Authorization") != "" {
+		signed, err = parseAuthHeader(req)
+	} else if req.URL.Query().Has("X-Amz-Signature") {
+		signed, err = parsePresignedQuery(req)
+	} else {
+		return errors.New("missing signature")
+	}
+	if err != nil {
+		return err
+	}
+
+	secretKey, ok := verifier.Keys[signed.accessKeyId]
+	if !ok {
+		return fmt.Errorf("unknown access key %q", signed.accessKeyId)
+	}
+	if signed.scope[0] != signed.date.Format("20060102") {
+		return fmt.Errorf("credential scope of %s for a request of %s", signed.scope[0], signed.date.Format(amzDateFormat))
+	}
+	maxSkew := verifier.MaxSkew
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+	// A presigned URL expires at its date plus X-Amz-Expires, the skew only allows for a date
+	// in the future.
+	if signed.expires > 0 && now.After(signed.date.Add(signed.expires)) {
+		return fmt.Errorf("presigned URL of %s expired", signed.date.Format(amzDateFormat))
+	}
+	if skew := now.Sub(signed.date); skew < -maxSkew || (signed.expires == 0 && skew > maxSkew) {
+		return fmt.Errorf("request of %s too far from the time of the server, %s", signed.date.Format(amzDateFormat), now.UTC().Format(amzDateFormat))
+	}
+
+	query := req.URL.Query()
+	query.Del("X-Amz-Signature")
+	cr := &CanonRequest{
+		Creds:       &credentials.Credentials{AccessKeyId: signed.accessKeyId, AccessSecretKey: secretKey},
+		Region:      signed.scope[1],
+		Service:     signed.scope[2],
+		httpMethod:  req.Method,
+		date:        signed.date.Format(amzDateFormat),
+		queryParams: query,
+		amzHeaders:  make(map[string]string, len(signed.signedHeaders)),
+		canonUri:    canonicalUri(req.URL.Path, signed.scope[2]),
+		payloadHash: signed.payloadHash,
+	}
+	for _, header := range signed.signedHeaders {
+		received := req.Header.Values(header)
+		if header == "host" {
+			received = []string{req.Host}
+		}
+		values := make([]string, len(received))
+		for i, v := range received {
+			values[i] = strings.Join(strings.Fields(v), " ")
+		}
+		cr.amzHeaders[header] = strings.Join(values, ",")
+	}
+	if !hmac.Equal([]byte(cr.SignatureV4()), []byte(signed.signature)) {
+		return errors.New("signature does not match")
+	}
+
+	if signed.payloadHash != UnsignedPayload && req.Body != nil && req.Body != http.NoBody {
+		req.Body = &verifiedPayload{ReadCloser: req.Body, sha: sha256.New(), expected: signed.payloadHash}
+	}
+	return nil
+}
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-auth-using-authorization-header.html
+func parseAuthHeader(req *http.Request) (*signedRequest, error) {
+	algorithm, fields, _ := strings.Cut(req.Header.Get("Authorization"), " ")
+	if algorithm != "AWS4-HMAC-SHA256" {
+		return nil, fmt.Errorf("unsupported signature algorithm %q", algorithm)
+	}
+	values := map[string]string{}
+	for _, field := range strings.Split(fields, ",") {
+		if name, value, ok := strings.Cut(strings.TrimSpace(field), "="); ok {
+			values[name] = value
+		}
+	}
+	signed := &signedRequest{signature: values["Signature"]}
+	if err := signed.parseScope(values["Credential"], values["SignedHeaders"]); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if amzDate := req.Header.Get("X-Amz-Date"); amzDate != "" {
+		signed.date, err = time.Parse(amzDateFormat, amzDate)
+	} else {
+		signed.date, err = http.ParseTime(req.Header.Get("Date"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid request date: %w", err)
+	}
+
+	signed.payloadHash = req.Header.Get("X-Amz-Content-Sha256")
+	switch {
+	case signed.payloadHash == "" && (req.Body == nil || req.Body == http.NoBody):
+		signed.payloadHash = EmptyPayloadHash
+	case signed.payloadHash == "":
+		return nil, errors.New("missing X-Amz-Content-Sha256")
+	case signed.payloadHash != UnsignedPayload && !isPayloadHash(signed.payloadHash):
+		// Streamed payloads would reach the service in aws-chunked encoding.
+		return nil, fmt.Errorf("unsupported payload hash %q", signed.payloadHash)
+	}
+	return signed, nil
+}
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-query-string-auth.html
+func parsePresignedQuery(req *http.Request) (*signedRequest, error) {
+	query := req.URL.Query()
+	if algorithm := query.Get("X-Amz-Algorithm"); algorithm != "AWS4-HMAC-SHA256" {
+		return nil, fmt.Errorf("unsupported signature algorithm %q", algorithm)
+	}
+	signed := &signedRequest{signature: query.Get("X-Amz-Signature"), payloadHash: UnsignedPayload}
+	if err := signed.parseScope(query.Get("X-Amz-Credential"), query.Get("X-Amz-SignedHeaders")); err != nil {
+		return nil, err
+	}
+	var err error
+	if signed.date, err = time.Parse(amzDateFormat, query.Get("X-Amz-Date")); err != nil {
+		return nil, fmt.Errorf("invalid X-Amz-Date: %w", err)
+	}
+	seconds, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	signed.expires = time.Duration(seconds) * time.Second
+	if err != nil || signed.expires <= 0 || signed.expires > maxPresignExpires {
+		return nil, fmt.Errorf("invalid X-Amz-Expires %q", query.Get("X-Amz-Expires"))
+	}
+	return signed, nil
+}
+
+// parseScope reads the credential, <access key id>/<date>/<region>/<service>/aws4_request, and
+// the semicolon separated list of signed headers, which includes host.
+func (signed *signedRequest) parseScope(credential string, signedHeaders string) error {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" {
+		return fmt.Errorf("invalid credential %q", credential)
+	}
+	signed.accessKeyId = parts[0]
+	signed.scope = parts[1:4]
+	signed.signedHeaders = strings.Split(strings.ToLower(signedHeaders), ";")
+	for _, header := range signed.signedHeaders {
+		if header == "host" {
+			return nil
+		}
+	}
+	return fmt.Errorf("the host header is not signed")
+}
+
+func isPayloadHash(s string) bool {
+	decoded, err := hex.DecodeString(s)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// verifiedPayload hashes a payload as it is read, failing at its end if it is not the one signed.
+type verifiedPayload struct {
+	io.ReadCloser
+	sha      hash.Hash
+	expected string
+}
+
+func (payload *verifiedPayload) Read(p []byte) (int, error) {
+	n, err := payload.ReadCloser.Read(p)
+	payload.sha.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(payload.sha.Sum(nil)) != strings.ToLower(payload.expected) {
+		return n, ErrPayloadMismatch
+	}
+	return n, err
+}
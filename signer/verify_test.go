@@ -0,0 +1,104 @@
+package signer
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/credentials"
+)
+
+func TestVerify(t *testing.T) {
+	crTemplate := CanonRequest{
+		Creds:   &credentials.Credentials{AccessKeyId: "CLIENT", AccessSecretKey: "SECRET"},
+		Region:  "us-east-1",
+		Service: "s3",
+	}
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	verifier := &Verifier{Keys: map[string]string{"CLIENT": "SECRET"}}
+	signed := func(payload string, tamper func(req *http.Request)) *http.Request {
+		req, _ := http.NewRequest(http.MethodPut, "https://gateway.example.com/my%20photo.txt?versionId=1", strings.NewReader(payload))
+		req.Header.Set("Content-Type", "text/plain")
+		cr := createCanonRequest(req, HashPayload([]byte(payload)), crTemplate, now)
+		req.Header.Set("Authorization", cr.AuthHeader())
+		// As received by a server.
+		req.Host = req.URL.Host
+		if tamper != nil {
+			tamper(req)
+		}
+		return req
+	}
+
+	if err := verifier.verify(signed("payload", nil), now.Add(time.Minute)); err != nil {
+		t.Errorf("expected a signed request to be verified, found %v", err)
+	}
+
+	testCases := []struct {
+		name   string
+		tamper func(req *http.Request)
+		now    time.Time
+	}{
+		{name: "unsigned", tamper: func(req *http.Request) { req.Header.Del("Authorization") }, now: now},
+		{name: "other path", tamper: func(req *http.Request) { req.URL.Path = "/other.txt" }, now: now},
+		{name: "other query", tamper: func(req *http.Request) { req.URL.RawQuery = "versionId=2" }, now: now},
+		{name: "other content type", tamper: func(req *http.Request) { req.Header.Set("Content-Type", "text/html") }, now: now},
+		{name: "unknown key", tamper: func(req *http.Request) {
+			req.Header.Set("Authorization", strings.Replace(req.Header.Get("Authorization"), "CLIENT", "OTHER", 1))
+		}, now: now},
+		{name: "clock skew", now: now.Add(16 * time.Minute)},
+		{name: "early", now: now.Add(-16 * time.Minute)},
+	}
+	for _, tt := range testCases {
+		if err := verifier.verify(signed("payload", tt.tamper), tt.now); err == nil {
+			t.Errorf("%s: expected the request to be rejected", tt.name)
+		}
+	}
+
+	req := signed("payload", nil)
+	if err := verifier.verify(req, now); err != nil {
+		t.Fatal(err)
+	}
+	if body, err := io.ReadAll(req.Body); err != nil || string(body) != "payload" {
+		t.Errorf("expected the payload, found %q, %v", body, err)
+	}
+	req = signed("payload", func(req *http.Request) { req.Body = io.NopCloser(strings.NewReader("tampered")) })
+	if err := verifier.verify(req, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(req.Body); !errors.Is(err, ErrPayloadMismatch) {
+		t.Errorf("expected ErrPayloadMismatch, found %v", err)
+	}
+}
+
+func TestVerifyPresigned(t *testing.T) {
+	crTemplate := CanonRequest{
+		Creds:   &credentials.Credentials{AccessKeyId: "CLIENT", AccessSecretKey: "SECRET"},
+		Region:  "us-east-1",
+		Service: "s3",
+	}
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	verifier := &Verifier{Keys: map[string]string{"CLIENT": "SECRET"}}
+	req, _ := http.NewRequest(http.MethodGet, "https://gateway.example.com/photo.txt", nil)
+	presigned, _ := http.NewRequest(http.MethodGet, presign(req, time.Hour, crTemplate, now).String(), nil)
+	presigned.Host = presigned.URL.Host
+
+	if err := verifier.verify(presigned, now.Add(59*time.Minute)); err != nil {
+		t.Errorf("expected a presigned URL to be verified, found %v", err)
+	}
+	if err := verifier.verify(presigned, now.Add(time.Hour)); err != nil {
+		t.Errorf("expected a presigned URL to be verified until it expires, found %v", err)
+	}
+	if err := verifier.verify(presigned, now.Add(time.Hour+time.Second)); err == nil {
+		t.Error("expected a presigned URL to be rejected a second after it expires")
+	}
+	if err := verifier.verify(presigned, now.Add(-16*time.Minute)); err == nil {
+		t.Error("expected a presigned URL dated too far in the future to be rejected")
+	}
+	presigned.Method = http.MethodPut
+	if err := verifier.verify(presigned, now); err == nil {
+		t.Error("expected a presigned URL to be rejected for another method")
+	}
+}
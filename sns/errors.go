@@ -0,0 +1,28 @@
+package sns
+
+import (
+	"github.com/bluecatengineering/traefik-aws-plugin/awsclient"
+	"github.com/bluecatengineering/traefik-aws-plugin/service"
+)
+
+var errorCodes = map[string]error{
+	"NotFound":                    service.ErrNotFound,
+	"AuthorizationError":          service.ErrAccessDenied,
+	"AccessDenied":                service.ErrAccessDenied,
+	"InvalidSecurity":             service.ErrAccessDenied,
+	"KMSAccessDenied":             service.ErrAccessDenied,
+	"Throttled":                   service.ErrThrottled,
+	"Throttling":                  service.ErrThrottled,
+	"KMSThrottling":               service.ErrThrottled,
+	"InvalidParameter":            service.ErrBadRequest,
+	"InvalidParameterValue":       service.ErrBadRequest,
+	"MissingParameter":            service.ErrBadRequest,
+	"InvalidParameterCombination": service.ErrBadRequest,
+	"ValidationError":             service.ErrBadRequest,
+	"EndpointDisabled":            service.ErrBadRequest,
+	"PlatformApplicationDisabled": service.ErrBadRequest,
+}
+
+// SNS speaks the query protocol, its errors are XML.
+// https://docs.aws.amazon.com/sns/latest/api/CommonErrors.html
+var protocol = awsclient.Protocol{Service: "SNS", ErrorCodes: errorCodes, DecodeError: awsclient.DecodeXMLError}
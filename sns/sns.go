@@ -0,0 +1,245 @@
+package sns
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/awsclient"
+	"github.com/bluecatengineering/traefik-aws-plugin/credentials"
+	"github.com/bluecatengineering/traefik-aws-plugin/log"
+	"github.com/bluecatengineering/traefik-aws-plugin/service"
+	"github.com/bluecatengineering/traefik-aws-plugin/signer"
+)
+
+// Actions are called with the query protocol, form encoded, and answer in XML.
+// https://docs.aws.amazon.com/sns/latest/api/CommonParameters.html
+const apiVersion = "2010-03-31"
+
+// maxMessageSize is the largest message SNS publishes, attributes included.
+const maxMessageSize = 256 << 10
+
+const maxMessageAttributes = 10
+
+// Headers of a message to a FIFO topic, which requires a group.
+const (
+	GroupIdHeader         = "X-Message-Group-Id"
+	DeduplicationIdHeader = "X-Message-Deduplication-Id"
+)
+
+// topic is where messages to a topic are published, signed for the region of its ARN.
+type topic struct {
+	arn      string
+	region   string
+	endpoint string
+	fifo     bool
+}
+
+// SNS publishes the body of POST requests to a topic: the default one at the root path, or the
+// one named by the path.
+type SNS struct {
+	client           *awsclient.Client
+	defaultTopic     *topic
+	topics           map[string]*topic
+	attributeHeaders []string
+}
+
+// New returns the service publishing to topicArn at the root path, and to the topics of
+// topicArns, by name, at /<name>. Either may be empty, not both. The headers of
+// attributeHeaders, when present in a request, are sent as String attributes of the message,
+// named after the header. Requests go to the regional endpoint of each topic unless endpoint,
+// such as the URL of a local implementation, is set.
+func New(topicArn string, topicArns map[string]string, attributeHeaders []string, timeoutSeconds int, endpoint string, creds *credentials.Store) (*SNS, error) {
+	if topicArn == "" && len(topicArns) == 0 {
+		return nil, fmt.Errorf("a topic ARN is required")
+	}
+	if len(attributeHeaders) > maxMessageAttributes {
+		return nil, fmt.Errorf("messages have %d attributes at most", maxMessageAttributes)
+	}
+	sns := &SNS{
+		client: awsclient.New(protocol, timeoutSeconds, creds),
+		topics: map[string]*topic{},
+	}
+	if topicArn != "" {
+		defaultTopic, err := parseTopic(topicArn, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		sns.defaultTopic = defaultTopic
+	}
+	for name, arn := range topicArns {
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid topic name %q", name)
+		}
+		namedTopic, err := parseTopic(arn, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		sns.topics[name] = namedTopic
+	}
+	for _, header := range attributeHeaders {
+		sns.attributeHeaders = append(sns.attributeHeaders, http.CanonicalHeaderKey(header))
+	}
+	return sns, nil
+}
+
+// parseTopic reads arn:<partition>:sns:<region>:<account>:<name>.
+func parseTopic(arn string, endpoint string) (*topic, error) {
+	parts := strings.Split(arn, ":")
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "sns" || parts[3] == "" || parts[4] == "" || parts[5] == "" {
+		return nil, fmt.Errorf("invalid topic ARN %q", arn)
+	}
+	if endpoint == "" {
+		suffix, ok := awsclient.PartitionSuffixes[parts[1]]
+		if !ok {
+			return nil, fmt.Errorf("topic %q: unknown partition %q", arn, parts[1])
+		}
+		endpoint = "https://sns." + parts[3] + "." + suffix + "/"
+	}
+	return &topic{
+		arn:      arn,
+		region:   parts[3],
+		endpoint: endpoint,
+		fifo:     strings.HasSuffix(parts[5], ".fifo"),
+	}, nil
+}
+
+// publishResult is the result of Publish, which Post responds with in JSON.
+// https://docs.aws.amazon.com/sns/latest/api/API_Publish.html#API_Publish_ResponseElements
+type publishResult struct {
+	MessageId      string `xml:"PublishResult>MessageId"`
+	SequenceNumber string `xml:"PublishResult>SequenceNumber" json:",omitempty"`
+}
+
+// Post publishes payload to the topic of name and responds with the MessageId, also set as
+// Location. Messages to a FIFO topic are in the group of the X-Message-Group-Id header and
+// deduplicated by X-Message-Deduplication-Id when set, by content otherwise, if the topic
+// allows it.
+// https://docs.aws.amazon.com/sns/latest/api/API_Publish.html
+func (sns *SNS) Post(name string, payload io.Reader, _ int64, header http.Header, rw http.ResponseWriter) ([]byte, error) {
+	target, err := sns.topic(name)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(io.LimitReader(payload, maxMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxMessageSize {
+		return nil, fmt.Errorf("%w: messages are %d bytes at most", service.ErrTooLarge, maxMessageSize)
+	}
+	if len(body) == 0 {
+		return nil, fmt.Errorf("%w: empty message", service.ErrBadRequest)
+	}
+	form := url.Values{}
+	form.Set("Action", "Publish")
+	form.Set("Version", apiVersion)
+	form.Set("TopicArn", target.arn)
+	form.Set("Message", string(body))
+	entry := 0
+	for _, attributeHeader := range sns.attributeHeaders {
+		value := header.Get(attributeHeader)
+		if value == "" {
+			continue
+		}
+		entry++
+		prefix := "MessageAttributes.entry." + strconv.Itoa(entry) + "."
+		form.Set(prefix+"Name", attributeHeader)
+		form.Set(prefix+"Value.DataType", "String")
+		form.Set(prefix+"Value.StringValue", value)
+	}
+	if target.fifo {
+		groupId := header.Get(GroupIdHeader)
+		if groupId == "" {
+			return nil, fmt.Errorf("%w: FIFO messages need a group, in %s", service.ErrBadRequest, GroupIdHeader)
+		}
+		form.Set("MessageGroupId", groupId)
+		if deduplicationId := header.Get(DeduplicationIdHeader); deduplicationId != "" {
+			form.Set("MessageDeduplicationId", deduplicationId)
+		}
+	}
+	result := &publishResult{}
+	if err = sns.call(target, form, result); err != nil {
+		return nil, err
+	}
+	log.Debug(fmt.Sprintf("message %s published to %s", result.MessageId, target.arn))
+	resp, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Add("Location", result.MessageId)
+	return resp, nil
+}
+
+// topic returns the topic a request to name publishes to.
+func (sns *SNS) topic(name string) (*topic, error) {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		if sns.defaultTopic == nil {
+			return nil, fmt.Errorf("%w: no default topic, publish to one of %s", service.ErrNotFound, strings.Join(sns.topicNames(), ", "))
+		}
+		return sns.defaultTopic, nil
+	}
+	target, ok := sns.topics[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown topic %q", service.ErrNotFound, name)
+	}
+	return target, nil
+}
+
+func (sns *SNS) topicNames() []string {
+	var names []string
+	for name := range sns.topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Put is not supported, publishing a message does not create anything at the path.
+func (sns *SNS) Put(_ string, _ io.Reader, _ int64, _ http.Header, _ http.ResponseWriter) ([]byte, error) {
+	return nil, fmt.Errorf("%w: PUT to a topic, use POST", service.ErrNotImplemented)
+}
+
+// Get is not supported, published messages are delivered to the subscriptions of the topic.
+func (sns *SNS) Get(_ string, _ *http.Request, _ http.ResponseWriter) error {
+	return fmt.Errorf("%w: GET on a topic", service.ErrNotImplemented)
+}
+
+// Head is not supported either.
+func (sns *SNS) Head(_ string, _ *http.Request, _ http.ResponseWriter) error {
+	return fmt.Errorf("%w: HEAD on a topic", service.ErrNotImplemented)
+}
+
+// Delete is not supported, a published message cannot be withdrawn.
+func (sns *SNS) Delete(_ string, _ http.ResponseWriter) error {
+	return fmt.Errorf("%w: DELETE on a topic", service.ErrNotImplemented)
+}
+
+// call sends an action to the endpoint of target and decodes its XML response into output.
+func (sns *SNS) call(target *topic, form url.Values, output interface{}) error {
+	action := form.Get("Action")
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	body, err := sns.client.Call(&awsclient.Call{
+		Action:       action,
+		Endpoint:     target.endpoint,
+		Header:       header,
+		Payload:      []byte(form.Encode()),
+		CanonRequest: signer.CanonRequest{Region: target.region, Service: "sns"},
+	})
+	if err != nil {
+		return err
+	}
+	if err = xml.Unmarshal(body, output); err != nil {
+		return fmt.Errorf("%s: invalid response: %w", action, err)
+	}
+	return nil
+}
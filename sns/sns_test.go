@@ -0,0 +1,181 @@
+package sns
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/awsclient"
+	"github.com/bluecatengineering/traefik-aws-plugin/credentials"
+	"github.com/bluecatengineering/traefik-aws-plugin/service"
+)
+
+// fakeSNS knows the topics of the account 123456789012 in us-east-1 and records what is
+// published to them.
+type fakeSNS struct {
+	mu        sync.Mutex
+	topics    map[string]bool
+	published []url.Values
+	// expired calls are rejected as signed with an expired token.
+	expired int
+}
+
+func (fake *fakeSNS) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fail := func(status int, code string) {
+		rw.WriteHeader(status)
+		_, _ = fmt.Fprintf(rw, `<ErrorResponse><Error><Type>Sender</Type><Code>%s</Code><Message>failed</Message></Error><RequestId>request</RequestId></ErrorResponse>`, code)
+	}
+	if !strings.Contains(req.Header.Get("Authorization"), "/us-east-1/sns/aws4_request") {
+		fail(http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+	if fake.expired > 0 {
+		fake.expired--
+		fail(http.StatusForbidden, "ExpiredToken")
+		return
+	}
+	if err := req.ParseForm(); err != nil || req.PostForm.Get("Action") != "Publish" || req.PostForm.Get("Version") != apiVersion {
+		fail(http.StatusBadRequest, "InvalidAction")
+		return
+	}
+	topicArn := req.PostForm.Get("TopicArn")
+	if !fake.topics[topicArn] {
+		fail(http.StatusNotFound, "NotFound")
+		return
+	}
+	if strings.HasSuffix(topicArn, ".fifo") && req.PostForm.Get("MessageGroupId") == "" {
+		fail(http.StatusBadRequest, "InvalidParameter")
+		return
+	}
+	fake.published = append(fake.published, req.PostForm)
+	_, _ = fmt.Fprintf(rw, `<PublishResponse xmlns="https://sns.amazonaws.com/doc/2010-03-31/"><PublishResult><MessageId>message-%d</MessageId></PublishResult><ResponseMetadata><RequestId>request</RequestId></ResponseMetadata></PublishResponse>`, len(fake.published))
+}
+
+const (
+	eventsArn = "arn:aws:sns:us-east-1:123456789012:events"
+	ordersArn = "arn:aws:sns:us-east-1:123456789012:orders.fifo"
+)
+
+func newFakeSNS(t *testing.T, topicArn string, topicArns map[string]string, attributeHeaders []string) (*fakeSNS, *SNS) {
+	fake := &fakeSNS{topics: map[string]bool{eventsArn: true, ordersArn: true}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	sns, err := New(topicArn, topicArns, attributeHeaders, 5, server.URL+"/", credentials.NewStore(&credentials.Static{AccessKeyId: "KEY", AccessSecretKey: "SECRET"}, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, sns
+}
+
+func TestPublish(t *testing.T) {
+	fake, sns := newFakeSNS(t, eventsArn, nil, []string{"x-github-event", "x-github-delivery"})
+	header := http.Header{}
+	header.Set("X-Github-Event", "push")
+	header.Set("X-Other", "ignored")
+	rec := httptest.NewRecorder()
+	resp, err := sns.Post("", strings.NewReader(`{"ref":"main"}`), -1, header, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != `{"MessageId":"message-1"}` || rec.Header().Get("Location") != "message-1" {
+		t.Errorf("unexpected response %s, location %q", resp, rec.Header().Get("Location"))
+	}
+	published := fake.published[0]
+	if published.Get("TopicArn") != eventsArn || published.Get("Message") != `{"ref":"main"}` {
+		t.Errorf("unexpected message %v", published)
+	}
+	if published.Get("MessageAttributes.entry.1.Name") != "X-Github-Event" ||
+		published.Get("MessageAttributes.entry.1.Value.DataType") != "String" ||
+		published.Get("MessageAttributes.entry.1.Value.StringValue") != "push" ||
+		published.Has("MessageAttributes.entry.2.Name") {
+		t.Errorf("expected the X-Github-Event attribute only, found %v", published)
+	}
+}
+
+func TestTopicFromPath(t *testing.T) {
+	fake, sns := newFakeSNS(t, "", map[string]string{"events": eventsArn, "orders": ordersArn}, nil)
+	if _, err := sns.Post("events", strings.NewReader("hello"), -1, http.Header{}, httptest.NewRecorder()); err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{}
+	header.Set(GroupIdHeader, "ada")
+	header.Set(DeduplicationIdHeader, "order-1")
+	if _, err := sns.Post("orders/", strings.NewReader("order"), -1, header, httptest.NewRecorder()); err != nil {
+		t.Fatal(err)
+	}
+	if fake.published[0].Get("TopicArn") != eventsArn || fake.published[1].Get("TopicArn") != ordersArn {
+		t.Errorf("expected the topics of the paths, found %v", fake.published)
+	}
+	if fake.published[1].Get("MessageGroupId") != "ada" || fake.published[1].Get("MessageDeduplicationId") != "order-1" {
+		t.Errorf("expected the group and deduplication headers, found %v", fake.published[1])
+	}
+
+	testCases := []struct {
+		name     string
+		path     string
+		expected error
+	}{
+		{name: "no default topic", path: "", expected: service.ErrNotFound},
+		{name: "unknown topic", path: "payments", expected: service.ErrNotFound},
+		{name: "FIFO without a group", path: "orders", expected: service.ErrBadRequest},
+	}
+	for _, tt := range testCases {
+		if _, err := sns.Post(tt.path, strings.NewReader("hello"), -1, http.Header{}, httptest.NewRecorder()); !errors.Is(err, tt.expected) {
+			t.Errorf("%s: expected %v, found %v", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	_, sns := newFakeSNS(t, eventsArn, nil, nil)
+	if _, err := sns.Post("", strings.NewReader(""), -1, http.Header{}, httptest.NewRecorder()); !errors.Is(err, service.ErrBadRequest) {
+		t.Errorf("expected ErrBadRequest for an empty message, found %v", err)
+	}
+	if _, err := sns.Post("", strings.NewReader(strings.Repeat("a", maxMessageSize+1)), -1, http.Header{}, httptest.NewRecorder()); !errors.Is(err, service.ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, found %v", err)
+	}
+	if _, err := sns.Put("", strings.NewReader("hello"), -1, http.Header{}, httptest.NewRecorder()); !errors.Is(err, service.ErrNotImplemented) {
+		t.Errorf("expected ErrNotImplemented for PUT, found %v", err)
+	}
+
+	_, sns = newFakeSNS(t, "arn:aws:sns:us-east-1:123456789012:missing", nil, nil)
+	_, err := sns.Post("", strings.NewReader("hello"), -1, http.Header{}, httptest.NewRecorder())
+	var snsErr *awsclient.Error
+	if !errors.Is(err, service.ErrNotFound) || !errors.As(err, &snsErr) || snsErr.Code != "NotFound" || snsErr.RequestId != "request" {
+		t.Errorf("expected a missing topic to be reported, found %v", err)
+	}
+}
+
+func TestExpiredToken(t *testing.T) {
+	fake, sns := newFakeSNS(t, eventsArn, nil, nil)
+	fake.expired = 1
+	if _, err := sns.Post("", strings.NewReader("hello"), -1, http.Header{}, httptest.NewRecorder()); err != nil {
+		t.Errorf("expected the call to be retried with renewed credentials, found %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	creds := credentials.NewStore(&credentials.Static{AccessKeyId: "KEY", AccessSecretKey: "SECRET"}, 0)
+	sns, err := New("arn:aws-cn:sns:cn-north-1:123456789012:events", nil, nil, 5, "", creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sns.defaultTopic.region != "cn-north-1" || sns.defaultTopic.endpoint != "https://sns.cn-north-1.amazonaws.com.cn/" {
+		t.Errorf("unexpected topic %+v", sns.defaultTopic)
+	}
+	for _, topicArn := range []string{"", "events", "arn:aws:sqs:us-east-1:123456789012:events", "arn:aws:sns:us-east-1:events"} {
+		if _, err = New(topicArn, nil, nil, 5, "", creds); err == nil {
+			t.Errorf("expected %q to be rejected", topicArn)
+		}
+	}
+	if _, err = New("", map[string]string{"a/b": eventsArn}, nil, 5, "", creds); err == nil {
+		t.Error("expected a topic name with a slash to be rejected")
+	}
+}
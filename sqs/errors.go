@@ -0,0 +1,35 @@
+package sqs
+
+import (
+	"github.com/bluecatengineering/traefik-aws-plugin/awsclient"
+	"github.com/bluecatengineering/traefik-aws-plugin/service"
+)
+
+// By the name of their exception, without the namespace of __type.
+var errorCodes = map[string]error{
+	"QueueDoesNotExist":           service.ErrNotFound,
+	"AccessDenied":                service.ErrAccessDenied,
+	"AccessDeniedException":       service.ErrAccessDenied,
+	"KmsAccessDenied":             service.ErrAccessDenied,
+	"RequestThrottled":            service.ErrThrottled,
+	"ThrottlingException":         service.ErrThrottled,
+	"KmsThrottled":                service.ErrThrottled,
+	"OverLimit":                   service.ErrThrottled,
+	"InvalidMessageContents":      service.ErrBadRequest,
+	"InvalidParameterValue":       service.ErrBadRequest,
+	"MissingParameter":            service.ErrBadRequest,
+	"ReceiptHandleIsInvalid":      service.ErrBadRequest,
+	"InvalidIdFormat":             service.ErrBadRequest,
+	"UnsupportedOperation":        service.ErrBadRequest,
+	"ValidationException":         service.ErrBadRequest,
+	"InvalidAddress":              service.ErrBadRequest,
+	"MessageNotInflight":          service.ErrBadRequest,
+	"InvalidAttributeName":        service.ErrBadRequest,
+	"InvalidAttributeValue":       service.ErrBadRequest,
+	"InvalidSecurity":             service.ErrAccessDenied,
+	"InvalidParameterCombination": service.ErrBadRequest,
+}
+
+// With the JSON protocol, SQS names its errors in __type as well.
+// https://docs.aws.amazon.com/AWSSimpleQueueService/latest/APIReference/CommonErrors.html
+var protocol = awsclient.Protocol{Service: "SQS", ErrorCodes: errorCodes, DecodeError: awsclient.DecodeJSONError}
@@ -0,0 +1,290 @@
+package sqs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/awsclient"
+	"github.com/bluecatengineering/traefik-aws-plugin/credentials"
+	"github.com/bluecatengineering/traefik-aws-plugin/log"
+	"github.com/bluecatengineering/traefik-aws-plugin/service"
+	"github.com/bluecatengineering/traefik-aws-plugin/signer"
+)
+
+// Operations are called with the JSON 1.0 protocol, named in X-Amz-Target.
+// https://docs.aws.amazon.com/AWSSimpleQueueService/latest/SQSDeveloperGuide/sqs-making-api-requests-json.html
+const (
+	contentType  = "application/x-amz-json-1.0"
+	targetPrefix = "AmazonSQS."
+)
+
+// maxMessageSize bounds the body read from a request, SQS rejecting what it finds too large.
+const maxMessageSize = 1 << 20
+
+// Limits of ReceiveMessage and of the attributes of a message.
+const (
+	maxWaitTimeSeconds   = 20
+	maxNumberOfMessages  = 10
+	maxMessageAttributes = 10
+)
+
+// Headers of a message to a FIFO queue, the group defaulting to the path of the request.
+const (
+	GroupIdHeader         = "X-Message-Group-Id"
+	DeduplicationIdHeader = "X-Message-Deduplication-Id"
+)
+
+// SQS sends the body of POST and PUT requests to a queue and receives its messages on GET.
+type SQS struct {
+	client           *awsclient.Client
+	crTemplate       signer.CanonRequest
+	endpoint         string
+	queueUrl         string
+	fifo             bool
+	attributeHeaders []string
+}
+
+// New returns the service for the queue at queueUrl. The headers of attributeHeaders, when
+// present in a request, are sent as String attributes of the message, named after the header.
+// Requests go to the host of the queue URL, which may be a local implementation, and are signed
+// for region, or the region of the host when empty.
+func New(queueUrl string, attributeHeaders []string, region string, timeoutSeconds int, creds *credentials.Store) (*SQS, error) {
+	if queueUrl == "" {
+		return nil, fmt.Errorf("a queue URL is required")
+	}
+	parsed, err := url.Parse(queueUrl)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+		return nil, fmt.Errorf("invalid queue URL %q", queueUrl)
+	}
+	if len(attributeHeaders) > maxMessageAttributes {
+		return nil, fmt.Errorf("messages have %d attributes at most", maxMessageAttributes)
+	}
+	if region == "" {
+		region = hostRegion(parsed.Host)
+	}
+	if region == "" {
+		return nil, fmt.Errorf("a region is required for the queue URL %q", queueUrl)
+	}
+	var canonHeaders []string
+	for _, header := range attributeHeaders {
+		canonHeaders = append(canonHeaders, http.CanonicalHeaderKey(header))
+	}
+	return &SQS{
+		client:           awsclient.New(protocol, timeoutSeconds, creds),
+		crTemplate:       signer.CanonRequest{Region: region, Service: "sqs"},
+		endpoint:         parsed.Scheme + "://" + parsed.Host + "/",
+		queueUrl:         queueUrl,
+		fifo:             strings.HasSuffix(parsed.Path, ".fifo"),
+		attributeHeaders: canonHeaders,
+	}, nil
+}
+
+// hostRegion returns the region of sqs.<region>.amazonaws.com, empty for any other host.
+func hostRegion(host string) string {
+	parts := strings.Split(host, ".")
+	if len(parts) >= 4 && parts[0] == "sqs" && parts[2] == "amazonaws" {
+		return parts[1]
+	}
+	return ""
+}
+
+// Put sends payload as a message and responds with the SQS result, MessageId included, the
+// message ID also set as Location. Messages to a FIFO queue are in the group of the
+// X-Message-Group-Id header, or name, and deduplicated by X-Message-Deduplication-Id when set,
+// by content otherwise, if the queue allows it.
+// https://docs.aws.amazon.com/AWSSimpleQueueService/latest/APIReference/API_SendMessage.html
+func (q *SQS) Put(name string, payload io.Reader, _ int64, header http.Header, rw http.ResponseWriter) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(payload, maxMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxMessageSize {
+		return nil, fmt.Errorf("%w: messages are %d bytes at most", service.ErrTooLarge, maxMessageSize)
+	}
+	if len(body) == 0 {
+		return nil, fmt.Errorf("%w: empty message", service.ErrBadRequest)
+	}
+	input := map[string]interface{}{"QueueUrl": q.queueUrl, "MessageBody": string(body)}
+	attributes := map[string]interface{}{}
+	for _, attributeHeader := range q.attributeHeaders {
+		if value := header.Get(attributeHeader); value != "" {
+			attributes[attributeHeader] = map[string]string{"DataType": "String", "StringValue": value}
+		}
+	}
+	if len(attributes) > 0 {
+		input["MessageAttributes"] = attributes
+	}
+	if q.fifo {
+		groupId := header.Get(GroupIdHeader)
+		if groupId == "" {
+			groupId = strings.Trim(name, "/")
+		}
+		if groupId == "" {
+			return nil, fmt.Errorf("%w: FIFO messages need a group, from the path or %s", service.ErrBadRequest, GroupIdHeader)
+		}
+		input["MessageGroupId"] = groupId
+		if deduplicationId := header.Get(DeduplicationIdHeader); deduplicationId != "" {
+			input["MessageDeduplicationId"] = deduplicationId
+		}
+	}
+	output := &struct {
+		MessageId      string
+		SequenceNumber string `json:",omitempty"`
+	}{}
+	if err = q.call("SendMessage", input, output, 0); err != nil {
+		return nil, err
+	}
+	log.Debug(fmt.Sprintf("message %s sent", output.MessageId))
+	result, err := json.Marshal(output)
+	if err != nil {
+		return nil, err
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Add("Location", output.MessageId)
+	return result, nil
+}
+
+// Post is Put, SQS naming the message.
+func (q *SQS) Post(name string, payload io.Reader, contentLength int64, header http.Header, rw http.ResponseWriter) ([]byte, error) {
+	return q.Put(name, payload, contentLength, header, rw)
+}
+
+// Message is a received message, as Get responds with in a JSON array. The receipt handle is
+// what deletes the message once processed; until then, it is received again when its
+// visibility timeout expires.
+type Message struct {
+	MessageId         string            `json:"messageId"`
+	ReceiptHandle     string            `json:"receiptHandle"`
+	Body              string            `json:"body"`
+	Attributes        map[string]string `json:"attributes,omitempty"`
+	MessageAttributes map[string]string `json:"messageAttributes,omitempty"`
+}
+
+// Get receives messages with long polling, waiting up to the wait-time-seconds query
+// parameter, 20 by default, for max-messages, 1 by default, to arrive. The response is an
+// empty array when none did.
+// https://docs.aws.amazon.com/AWSSimpleQueueService/latest/APIReference/API_ReceiveMessage.html
+func (q *SQS) Get(_ string, req *http.Request, rw http.ResponseWriter) error {
+	query := req.URL.Query()
+	waitTimeSeconds, err := intParameter(query, "wait-time-seconds", maxWaitTimeSeconds, 0, maxWaitTimeSeconds)
+	if err != nil {
+		return err
+	}
+	maxMessages, err := intParameter(query, "max-messages", 1, 1, maxNumberOfMessages)
+	if err != nil {
+		return err
+	}
+	input := map[string]interface{}{
+		"QueueUrl":              q.queueUrl,
+		"MaxNumberOfMessages":   maxMessages,
+		"WaitTimeSeconds":       waitTimeSeconds,
+		"AttributeNames":        []string{"All"},
+		"MessageAttributeNames": []string{"All"},
+	}
+	output := &struct {
+		Messages []struct {
+			MessageId         string
+			ReceiptHandle     string
+			Body              string
+			Attributes        map[string]string
+			MessageAttributes map[string]struct {
+				DataType    string
+				StringValue string
+				BinaryValue string
+			}
+		}
+	}{}
+	if err = q.call("ReceiveMessage", input, output, time.Duration(waitTimeSeconds)*time.Second); err != nil {
+		return err
+	}
+	messages := []Message{}
+	for _, received := range output.Messages {
+		message := Message{
+			MessageId:     received.MessageId,
+			ReceiptHandle: received.ReceiptHandle,
+			Body:          received.Body,
+			Attributes:    received.Attributes,
+		}
+		for name, attribute := range received.MessageAttributes {
+			if message.MessageAttributes == nil {
+				message.MessageAttributes = map[string]string{}
+			}
+			if strings.HasPrefix(attribute.DataType, "Binary") {
+				// Base64, as SQS encodes it.
+				message.MessageAttributes[name] = attribute.BinaryValue
+			} else {
+				message.MessageAttributes[name] = attribute.StringValue
+			}
+		}
+		messages = append(messages, message)
+	}
+	body, err := json.Marshal(messages)
+	if err != nil {
+		return err
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write(body)
+	return err
+}
+
+// intParameter returns the integer query parameter, or defaultValue when absent.
+func intParameter(query url.Values, name string, defaultValue, min, max int) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%w: %s must be between %d and %d", service.ErrBadRequest, name, min, max)
+	}
+	return n, nil
+}
+
+// Head is not supported, there is nothing to describe at a path.
+func (q *SQS) Head(_ string, _ *http.Request, _ http.ResponseWriter) error {
+	return fmt.Errorf("%w: HEAD on a queue", service.ErrNotImplemented)
+}
+
+// Delete deletes the message whose receipt handle is name, once it is processed.
+// https://docs.aws.amazon.com/AWSSimpleQueueService/latest/APIReference/API_DeleteMessage.html
+func (q *SQS) Delete(name string, _ http.ResponseWriter) error {
+	if name == "" {
+		return fmt.Errorf("%w: missing receipt handle", service.ErrBadRequest)
+	}
+	return q.call("DeleteMessage", map[string]interface{}{"QueueUrl": q.queueUrl, "ReceiptHandle": name}, nil, 0)
+}
+
+// call sends an operation and decodes its response into output, unless nil. The response may
+// take up to wait on top of the configured timeout.
+func (q *SQS) call(operation string, input interface{}, output interface{}, wait time.Duration) error {
+	payload, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	header.Set("X-Amz-Target", targetPrefix+operation)
+	body, err := q.client.Call(&awsclient.Call{
+		Action:       operation,
+		Endpoint:     q.endpoint,
+		Header:       header,
+		Payload:      payload,
+		CanonRequest: q.crTemplate,
+		Wait:         wait,
+	})
+	if err != nil || output == nil {
+		return err
+	}
+	if err = json.Unmarshal(body, output); err != nil {
+		return fmt.Errorf("%s: invalid response: %w", operation, err)
+	}
+	return nil
+}
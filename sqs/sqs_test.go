@@ -0,0 +1,222 @@
+package sqs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/awsclient"
+	"github.com/bluecatengineering/traefik-aws-plugin/credentials"
+	"github.com/bluecatengineering/traefik-aws-plugin/service"
+)
+
+// fakeSQS is a minimal in-memory queue speaking just enough of the JSON 1.0 protocol for the
+// tests. Received messages stay in flight until deleted.
+type fakeSQS struct {
+	mu       sync.Mutex
+	queueUrl string
+	sent     []map[string]interface{}
+	inFlight map[string]map[string]interface{}
+	// waits are the WaitTimeSeconds of the ReceiveMessage calls.
+	waits []float64
+	// expired calls are rejected as signed with an expired token.
+	expired int
+}
+
+func (fake *fakeSQS) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fail := func(status int, exception string) {
+		rw.WriteHeader(status)
+		_, _ = fmt.Fprintf(rw, `{"__type":"com.amazonaws.sqs#%s","message":"failed"}`, exception)
+	}
+	if req.Header.Get("Content-Type") != contentType || !strings.Contains(req.Header.Get("Authorization"), "/us-east-1/sqs/aws4_request") {
+		fail(http.StatusBadRequest, "MissingAuthenticationToken")
+		return
+	}
+	if fake.expired > 0 {
+		fake.expired--
+		fail(http.StatusBadRequest, "ExpiredToken")
+		return
+	}
+	input := map[string]interface{}{}
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		fail(http.StatusBadRequest, "InvalidParameterValue")
+		return
+	}
+	if input["QueueUrl"] != fake.queueUrl {
+		fail(http.StatusBadRequest, "QueueDoesNotExist")
+		return
+	}
+	switch strings.TrimPrefix(req.Header.Get("X-Amz-Target"), targetPrefix) {
+	case "SendMessage":
+		if strings.HasSuffix(fake.queueUrl, ".fifo") && input["MessageGroupId"] == nil {
+			fail(http.StatusBadRequest, "MissingParameter")
+			return
+		}
+		fake.sent = append(fake.sent, input)
+		_, _ = fmt.Fprintf(rw, `{"MD5OfMessageBody":"md5","MessageId":"message-%d"}`, len(fake.sent))
+	case "ReceiveMessage":
+		fake.waits = append(fake.waits, input["WaitTimeSeconds"].(float64))
+		var messages []map[string]interface{}
+		for len(fake.sent) > 0 && len(messages) < int(input["MaxNumberOfMessages"].(float64)) {
+			sent := fake.sent[0]
+			fake.sent = fake.sent[1:]
+			handle := fmt.Sprintf("handle/%d+", len(fake.inFlight))
+			fake.inFlight[handle] = sent
+			messages = append(messages, map[string]interface{}{
+				"MessageId":         "message",
+				"ReceiptHandle":     handle,
+				"Body":              sent["MessageBody"],
+				"Attributes":        map[string]string{"ApproximateReceiveCount": "1"},
+				"MessageAttributes": sent["MessageAttributes"],
+			})
+		}
+		_ = json.NewEncoder(rw).Encode(map[string]interface{}{"Messages": messages})
+	case "DeleteMessage":
+		handle, _ := input["ReceiptHandle"].(string)
+		if fake.inFlight[handle] == nil {
+			fail(http.StatusBadRequest, "ReceiptHandleIsInvalid")
+			return
+		}
+		delete(fake.inFlight, handle)
+		_, _ = rw.Write([]byte(`{}`))
+	default:
+		fail(http.StatusBadRequest, "UnsupportedOperation")
+	}
+}
+
+func newFakeSQS(t *testing.T, queue string, attributeHeaders []string) (*fakeSQS, *SQS) {
+	fake := &fakeSQS{inFlight: map[string]map[string]interface{}{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	fake.queueUrl = server.URL + "/123456789012/" + queue
+	q, err := New(fake.queueUrl, attributeHeaders, "us-east-1", 5, credentials.NewStore(&credentials.Static{AccessKeyId: "KEY", AccessSecretKey: "SECRET"}, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, q
+}
+
+func TestSendReceiveDelete(t *testing.T) {
+	fake, q := newFakeSQS(t, "webhooks", []string{"x-github-event"})
+	header := http.Header{}
+	header.Set("X-Github-Event", "push")
+	header.Set("X-Other", "ignored")
+	rec := httptest.NewRecorder()
+	resp, err := q.Post("github", strings.NewReader(`{"ref":"main"}`), -1, header, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != `{"MessageId":"message-1"}` || rec.Header().Get("Location") != "message-1" {
+		t.Errorf("unexpected response %s, location %q", resp, rec.Header().Get("Location"))
+	}
+	sent, _ := json.Marshal(fake.sent[0])
+	expected := `{"MessageAttributes":{"X-Github-Event":{"DataType":"String","StringValue":"push"}},"MessageBody":"{\"ref\":\"main\"}","QueueUrl":"` + fake.queueUrl + `"}`
+	if string(sent) != expected {
+		t.Errorf("unexpected message\nexpected: %s\nfound:    %s", expected, sent)
+	}
+
+	rec = httptest.NewRecorder()
+	if err = q.Get("", httptest.NewRequest(http.MethodGet, "/?max-messages=10", nil), rec); err != nil {
+		t.Fatal(err)
+	}
+	var messages []Message
+	if err = json.Unmarshal(rec.Body.Bytes(), &messages); err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].Body != `{"ref":"main"}` || messages[0].MessageAttributes["X-Github-Event"] != "push" {
+		t.Fatalf("unexpected messages %s", rec.Body.String())
+	}
+	if len(fake.waits) != 1 || fake.waits[0] != maxWaitTimeSeconds {
+		t.Errorf("expected long polling by default, found %v", fake.waits)
+	}
+
+	if err = q.Delete(messages[0].ReceiptHandle, httptest.NewRecorder()); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Delete(messages[0].ReceiptHandle, httptest.NewRecorder()); !errors.Is(err, service.ErrBadRequest) {
+		t.Errorf("expected ErrBadRequest deleting twice, found %v", err)
+	}
+
+	rec = httptest.NewRecorder()
+	if err = q.Get("", httptest.NewRequest(http.MethodGet, "/?wait-time-seconds=0", nil), rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Body.String() != "[]" || fake.waits[1] != 0 {
+		t.Errorf("expected an empty array without waiting, found %s, %v", rec.Body.String(), fake.waits)
+	}
+}
+
+func TestFifo(t *testing.T) {
+	fake, q := newFakeSQS(t, "orders.fifo", nil)
+	if _, err := q.Put("customers/ada", strings.NewReader("order"), -1, http.Header{}, httptest.NewRecorder()); err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{}
+	header.Set(GroupIdHeader, "ada")
+	header.Set(DeduplicationIdHeader, "order-1")
+	if _, err := q.Put("customers/ada", strings.NewReader("order"), -1, header, httptest.NewRecorder()); err != nil {
+		t.Fatal(err)
+	}
+	if fake.sent[0]["MessageGroupId"] != "customers/ada" || fake.sent[0]["MessageDeduplicationId"] != nil {
+		t.Errorf("expected the path as group, found %v", fake.sent[0])
+	}
+	if fake.sent[1]["MessageGroupId"] != "ada" || fake.sent[1]["MessageDeduplicationId"] != "order-1" {
+		t.Errorf("expected the group and deduplication headers, found %v", fake.sent[1])
+	}
+	if _, err := q.Put("", strings.NewReader("order"), -1, http.Header{}, httptest.NewRecorder()); !errors.Is(err, service.ErrBadRequest) {
+		t.Errorf("expected ErrBadRequest without a group, found %v", err)
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	_, q := newFakeSQS(t, "webhooks", nil)
+	if _, err := q.Post("", strings.NewReader(""), -1, http.Header{}, httptest.NewRecorder()); !errors.Is(err, service.ErrBadRequest) {
+		t.Errorf("expected ErrBadRequest for an empty message, found %v", err)
+	}
+	if _, err := q.Post("", strings.NewReader(strings.Repeat("a", maxMessageSize+1)), -1, http.Header{}, httptest.NewRecorder()); !errors.Is(err, service.ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, found %v", err)
+	}
+	for _, query := range []string{"wait-time-seconds=21", "max-messages=0", "max-messages=a"} {
+		if err := q.Get("", httptest.NewRequest(http.MethodGet, "/?"+query, nil), httptest.NewRecorder()); !errors.Is(err, service.ErrBadRequest) {
+			t.Errorf("%s: expected ErrBadRequest, found %v", query, err)
+		}
+	}
+
+	q.queueUrl += "-missing"
+	_, err := q.Post("", strings.NewReader("hello"), -1, http.Header{}, httptest.NewRecorder())
+	var sqsErr *awsclient.Error
+	if !errors.Is(err, service.ErrNotFound) || !errors.As(err, &sqsErr) || sqsErr.Code != "QueueDoesNotExist" {
+		t.Errorf("expected a missing queue to be reported, found %v", err)
+	}
+}
+
+func TestExpiredToken(t *testing.T) {
+	fake, q := newFakeSQS(t, "webhooks", nil)
+	fake.expired = 1
+	if _, err := q.Post("", strings.NewReader("hello"), -1, http.Header{}, httptest.NewRecorder()); err != nil {
+		t.Errorf("expected the call to be retried with renewed credentials, found %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	creds := credentials.NewStore(&credentials.Static{AccessKeyId: "KEY", AccessSecretKey: "SECRET"}, 0)
+	q, err := New("https://sqs.eu-west-3.amazonaws.com/123456789012/webhooks", nil, "", 5, creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.crTemplate.Region != "eu-west-3" || q.endpoint != "https://sqs.eu-west-3.amazonaws.com/" || q.fifo {
+		t.Errorf("unexpected region %q, endpoint %q", q.crTemplate.Region, q.endpoint)
+	}
+	for _, queueUrl := range []string{"", "sqs.eu-west-3.amazonaws.com/123456789012/webhooks", "http://localhost:9324/queue/webhooks"} {
+		if _, err = New(queueUrl, nil, "", 5, creds); err == nil {
+			t.Errorf("expected %q to be rejected without a region", queueUrl)
+		}
+	}
+}
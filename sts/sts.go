@@ -0,0 +1,119 @@
+package sts
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/credentials"
+	"github.com/bluecatengineering/traefik-aws-plugin/signer"
+)
+
+const defaultSessionName = "traefik-aws-plugin"
+
+// AssumeRole retrieves temporary credentials for a role, typically in another account,
+// signing the STS request with the credentials of its base provider.
+// https://docs.aws.amazon.com/STS/latest/APIReference/API_AssumeRole.html
+type AssumeRole struct {
+	base        credentials.Provider
+	roleArn     string
+	externalId  string
+	sessionName string
+	region      string
+	endpoint    string
+	client      *http.Client
+}
+
+// https://docs.aws.amazon.com/STS/latest/APIReference/API_AssumeRole.html#API_AssumeRole_ResponseElements
+type assumeRoleResponse struct {
+	Credentials struct {
+		AccessKeyId     string    `xml:"AccessKeyId"`
+		SecretAccessKey string    `xml:"SecretAccessKey"`
+		SessionToken    string    `xml:"SessionToken"`
+		Expiration      time.Time `xml:"Expiration"`
+	} `xml:"AssumeRoleResult>Credentials"`
+}
+
+// https://docs.aws.amazon.com/STS/latest/APIReference/CommonErrors.html
+type errorResponse struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+// NewAssumeRole returns a provider assuming roleArn with the credentials of base. STS is
+// called in region, or globally when region is empty.
+func NewAssumeRole(base credentials.Provider, roleArn, externalId, sessionName, region string) *AssumeRole {
+	if sessionName == "" {
+		sessionName = defaultSessionName
+	}
+	return &AssumeRole{
+		base:        base,
+		roleArn:     roleArn,
+		externalId:  externalId,
+		sessionName: sessionName,
+		region:      region,
+		endpoint:    credentials.StsEndpoint(region),
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (assumeRole *AssumeRole) Retrieve() (*credentials.Credentials, error) {
+	baseCreds, err := assumeRole.base.Retrieve()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("Action", "AssumeRole")
+	form.Set("Version", "2011-06-15")
+	form.Set("RoleArn", assumeRole.roleArn)
+	form.Set("RoleSessionName", assumeRole.sessionName)
+	if assumeRole.externalId != "" {
+		form.Set("ExternalId", assumeRole.externalId)
+	}
+	payload := form.Encode()
+	req, err := http.NewRequest(http.MethodPost, assumeRole.endpoint, strings.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	req.Header.Set("Host", req.URL.Host)
+	// The global endpoint is signed for us-east-1.
+	region := assumeRole.region
+	if region == "" {
+		region = "us-east-1"
+	}
+	crTemplate := signer.CanonRequest{Creds: baseCreds, Region: region, Service: "sts"}
+	cr := signer.CreateCanonRequest(req, signer.HashPayload([]byte(payload)), crTemplate)
+	req.Header.Set("Authorization", cr.AuthHeader())
+
+	resp, err := assumeRole.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("assuming role %q: %w", assumeRole.roleArn, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("assuming role %q: %w", assumeRole.roleArn, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		stsErr := &errorResponse{}
+		_ = xml.Unmarshal(body, stsErr)
+		return nil, fmt.Errorf("assuming role %q: STS responded with %s: %s: %s", assumeRole.roleArn, resp.Status, stsErr.Code, stsErr.Message)
+	}
+	result := &assumeRoleResponse{}
+	if err = xml.Unmarshal(body, result); err != nil {
+		return nil, fmt.Errorf("assuming role %q: %w", assumeRole.roleArn, err)
+	}
+	return &credentials.Credentials{
+		AccessKeyId:     result.Credentials.AccessKeyId,
+		AccessSecretKey: result.Credentials.SecretAccessKey,
+		SecurityToken:   result.Credentials.SessionToken,
+		RoleArn:         assumeRole.roleArn,
+		Expiration:      result.Credentials.Expiration,
+	}, nil
+}
@@ -0,0 +1,40 @@
+package sts
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bluecatengineering/traefik-aws-plugin/credentials"
+)
+
+func TestAssumeRole(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if !strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=BASE_KEY/") ||
+			!strings.Contains(req.Header.Get("Authorization"), "/eu-west-1/sts/aws4_request") {
+			rw.WriteHeader(http.StatusForbidden)
+			_, _ = rw.Write([]byte(`<ErrorResponse><Error><Code>SignatureDoesNotMatch</Code><Message>bad signature</Message></Error></ErrorResponse>`))
+			return
+		}
+		_ = req.ParseForm()
+		if req.Form.Get("Action") != "AssumeRole" || req.Form.Get("RoleArn") != "arn:aws:iam::123456789012:role/bucket" || req.Form.Get("ExternalId") != "external" {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = rw.Write([]byte(`<AssumeRoleResponse><AssumeRoleResult><Credentials>
+<AccessKeyId>ROLE_KEY</AccessKeyId><SecretAccessKey>ROLE_SECRET</SecretAccessKey><SessionToken>ROLE_TOKEN</SessionToken>
+<Expiration>2030-01-01T00:00:00Z</Expiration></Credentials></AssumeRoleResult></AssumeRoleResponse>`))
+	}))
+	defer server.Close()
+
+	assumeRole := NewAssumeRole(&credentials.Static{AccessKeyId: "BASE_KEY", AccessSecretKey: "BASE_SECRET"}, "arn:aws:iam::123456789012:role/bucket", "external", "", "eu-west-1")
+	assumeRole.endpoint = server.URL + "/"
+	creds, err := assumeRole.Retrieve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyId != "ROLE_KEY" || creds.AccessSecretKey != "ROLE_SECRET" || creds.SecurityToken != "ROLE_TOKEN" || creds.Expiration.Year() != 2030 {
+		t.Errorf("unexpected credentials %+v", creds)
+	}
+}